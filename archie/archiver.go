@@ -2,34 +2,77 @@ package archie
 
 import (
 	"archie/client"
+	"archie/encryption"
+	"github.com/nats-io/nats.go"
 	"go.arsenm.dev/pcre"
+	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 type Archiver struct {
 	BackoffDurationMultiplier uint64
 	BackoffNumCeiling         uint64
-	DestBucket                string
-	DestClient                client.Client
-	DestName                  string
-	DestPartSize              uint64
-	DestThreads               uint
+	ChecksumAlgorithm         string
+	ChecksumReadBack          bool
+	ChecksumRecord            bool
+	DeadLetterSubject         string
+	DefaultRoute              *Route
+	DeleteBreaker             *DeleteBreaker
+	DeletePolicy              DeletePolicy
+	Destinations              []*Destination
+	DryRun                    bool
+	Encryption                *encryption.Encryptor
 	FetchDone                 chan string
+	Filters                   ObjectFilters
 	HealthCheckDisabled       bool
 	IsOffline                 bool
+	JetStream                 nats.JetStreamContext
+	KeyIndexKV                nats.KeyValue
+	KeyRules                  []KeyRule
+	Manifest                  *Manifest
 	MaxRetries                uint64
+	Metadata                  MetadataOptions
 	MsgTimeout                string
+	ProgressKV                nats.KeyValue
+	Routes                    []*Route
 	SkipEventBucketValidation bool
+	SkipIdentical             bool
 	SkipLifecycleExpired      bool
 	SrcBucket                 string
 	SrcClient                 client.Client
 	SrcName                   string
 	WaitForMatchingETag       bool
 	WaitGroup                 *sync.WaitGroup
+	Workers                   int
 	ExcludePaths              struct {
 		CopyObject   []*pcre.Regexp
 		RemoveObject []*pcre.Regexp
 	}
+	IncludePaths struct {
+		CopyObject   []*pcre.Regexp
+		RemoveObject []*pcre.Regexp
+	}
+
+	// the handlers of the metrics server
+	metricsMux *http.ServeMux
+
+	// deletes held by the delay policy
+	pendingDeletes pendingDeletes
+
+	// last report served by the reconciler
+	reconcileReport atomic.Pointer[ReconcileReport]
+}
+
+type Destination struct {
+	Bucket   string
+	Client   client.Client
+	Name     string
+	PartSize uint64
+	Threads  uint
+
+	// the destination shares the source's backend and endpoint so objects are copied without streaming
+	ServerSideCopy bool
 }
 
 type AckType int
@@ -41,6 +84,8 @@ const (
 	Term
 	NakThenTerm
 	None
+	// the handler already naked the message with its own delay
+	Delayed
 )
 
 func (s AckType) String() string {
@@ -55,6 +100,8 @@ func (s AckType) String() string {
 		return "nak_then_term"
 	case None:
 		return "none"
+	case Delayed:
+		return "delayed"
 	}
 	return "unknown"
 }
@@ -0,0 +1,416 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// save a checkpoint after this many source objects
+const backfillCheckpointInterval = 1000
+
+// the checkpoint keeps up to this many failed keys to retry, past it the checkpoint stops moving
+const backfillMaxRetryKeys = 10000
+
+type BackfillOptions struct {
+	CheckpointKV nats.KeyValue
+	Prefix       string
+	Restart      bool
+
+	// enqueue copy events to this subject, without a subject the objects are copied directly
+	Subject string
+}
+
+type BackfillResult struct {
+	Copied   uint64 `json:"copied"`
+	Enqueued uint64 `json:"enqueued"`
+	Failed   uint64 `json:"failed"`
+	InSync   uint64 `json:"inSync"`
+	Listed   uint64 `json:"listed"`
+	Skipped  uint64 `json:"skipped"`
+}
+
+// the markers resume the listings of the clients that can't start after a key, the destinations' by name,
+// the keys that failed before the start are retried first
+type backfillCheckpoint struct {
+	DestinationMarkers map[string]string `json:"destinationMarkers,omitempty"`
+	Prefix             string            `json:"prefix"`
+	Result             BackfillResult    `json:"result"`
+	Retry              []string          `json:"retry,omitempty"`
+	SourceMarker       string            `json:"sourceMarker,omitempty"`
+	StartAfter         string            `json:"startAfter"`
+	Updated            time.Time         `json:"updated"`
+}
+
+// backfillObject is a source object with the destinations that are missing it or differ
+type backfillObject struct {
+	info         client.ObjectInfo
+	destinations []*Destination
+}
+
+// Backfill lists the source bucket, compares it against each destination by size and ETag,
+// then enqueues or copies the objects that differ, resuming from the last checkpoint
+func (a *Archiver) Backfill(ctx context.Context, opts BackfillOptions) (BackfillResult, error) {
+	checkpoint := a.loadBackfillCheckpoint(opts)
+	result := checkpoint.Result
+
+	if checkpoint.StartAfter != "" {
+		log.Info().Str("startAfter", checkpoint.StartAfter).Interface("result", result).Msg("Resuming the backfill from the checkpoint")
+	}
+
+	// canceling the listings stops their goroutines on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	listOpts := client.ListOptions{StartAfter: checkpoint.StartAfter}
+	srcOpts := listOpts
+	srcOpts.Marker = checkpoint.SourceMarker
+
+	srcCursor := newListCursor(a.SrcClient.ListObjects(listCtx, a.SrcBucket, opts.Prefix, srcOpts))
+	destCursors := a.destinationCursors(listCtx, opts.Prefix, listOpts, checkpoint.DestinationMarkers)
+
+	workers := a.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// the keys that failed before the checkpoint aren't listed again
+	retry := checkpoint.Retry
+	if len(retry) > 0 {
+		log.Info().Int("keys", len(retry)).Msg("Retrying the keys that failed before the checkpoint")
+		var err error
+		retry, err = a.backfillRetry(ctx, retry, opts, &result, workers)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	var batch []backfillObject
+	var sinceCheckpoint int
+	// a resumed backfill that lists nothing new keeps its checkpoint position
+	last := client.ObjectInfo{Key: checkpoint.StartAfter, Marker: checkpoint.SourceMarker}
+
+	save := func() {
+		checkpoint.StartAfter = last.Key
+		checkpoint.SourceMarker = last.Marker
+		markers := map[string]string{}
+		for i, dest := range a.Destinations {
+			if marker := destCursors[i].marker(); marker != "" {
+				markers[dest.Name] = marker
+			} else if marker := checkpoint.DestinationMarkers[dest.Name]; marker != "" {
+				markers[dest.Name] = marker
+			}
+		}
+		checkpoint.DestinationMarkers = markers
+		checkpoint.Result = result
+		checkpoint.Retry = retry
+		a.saveBackfillCheckpoint(opts, checkpoint)
+	}
+
+	flush := func() {
+		retry = append(retry, a.backfillBatch(ctx, batch, opts, &result, workers)...)
+		batch = batch[:0]
+
+		// an interrupted batch is retried from the previous checkpoint
+		if sinceCheckpoint < backfillCheckpointInterval || ctx.Err() != nil {
+			return
+		}
+		sinceCheckpoint = 0
+
+		// once there are too many failed keys to keep the checkpoint stays before them so they're listed again
+		if len(retry) > backfillMaxRetryKeys {
+			log.Error().Int("failed", len(retry)).Msg("Too many failed keys to retry, the backfill checkpoint isn't moved")
+			return
+		}
+		save()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		err := srcCursor.next()
+		if err != nil {
+			return result, fmt.Errorf("failed to list the source bucket: %w", err)
+		}
+		if srcCursor.current == nil {
+			break
+		}
+
+		src := *srcCursor.current
+		result.Listed++
+
+		// the objects the copy events would skip aren't compared
+		skipped, err := a.listedCopySkip(ctx, src)
+		if err != nil {
+			log.Error().Err(err).Str("key", src.Key).Msg("Failed to stat the backfill source object")
+			result.Failed++
+			retry = append(retry, src.Key)
+		} else if skipped != "" {
+			log.Debug().Str("key", src.Key).Str("skipped", skipped).Msg("Backfill object skipped")
+			result.Skipped++
+		} else {
+			destinations, err := a.backfillDestinations(ctx, src, destCursors)
+			if err != nil {
+				return result, err
+			}
+
+			if len(destinations) == 0 {
+				result.InSync++
+			} else {
+				batch = append(batch, backfillObject{info: src, destinations: destinations})
+			}
+		}
+
+		last = src
+		sinceCheckpoint++
+		if len(batch) >= workers || sinceCheckpoint >= backfillCheckpointInterval {
+			flush()
+		}
+	}
+
+	retry = append(retry, a.backfillBatch(ctx, batch, opts, &result, workers)...)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	// the backfill isn't finished until its failed keys are copied, the next run retries them
+	// and only lists the keys after the last one
+	if len(retry) > 0 {
+		if len(retry) <= backfillMaxRetryKeys {
+			save()
+		}
+		log.Error().Int("failed", len(retry)).Msg("Backfill keys failed, the next run retries them")
+		return result, nil
+	}
+
+	// a finished backfill starts over on the next run
+	if opts.CheckpointKV != nil && !a.DryRun {
+		err := opts.CheckpointKV.Purge(backfillCheckpointKey(a.SrcBucket, opts.Prefix))
+		if err != nil && err != nats.ErrKeyNotFound {
+			log.Error().Err(err).Msg("Failed to clear the backfill checkpoint")
+		}
+	}
+
+	return result, nil
+}
+
+// the destinations of the source object's route that are missing it or differ
+func (a *Archiver) backfillDestinations(ctx context.Context, src client.ObjectInfo, destCursors []*listCursor) ([]*Destination, error) {
+	route := a.route(src.Key)
+
+	var destinations []*Destination
+	for i, dest := range a.Destinations {
+		if !route.copies(dest) {
+			continue
+		}
+
+		destInfo, err := a.destinationObject(ctx, dest, destCursors[i], src, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the %s destination bucket: %w", dest.Name, err)
+		}
+
+		difference := a.objectDifference(src, destInfo)
+		if difference != "" {
+			log.Debug().Str("key", src.Key).Str("destination", dest.Name).Str("difference", difference).Msg("Backfill difference found")
+			destinations = append(destinations, dest)
+		}
+	}
+	return destinations, nil
+}
+
+// compare and copy the keys that failed on an earlier run, the ones that fail again are returned
+func (a *Archiver) backfillRetry(ctx context.Context, keys []string, opts BackfillOptions, result *BackfillResult, workers int) ([]string, error) {
+	// the destination objects are looked up by their key
+	destCursors := make([]*listCursor, len(a.Destinations))
+
+	var failed []string
+	var batch []backfillObject
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+
+		src, err := a.SrcClient.StatObject(ctx, a.SrcBucket, key, client.GetOptions{})
+		if err != nil {
+			if isObjectNotFound(err) {
+				log.Info().Str("key", key).Msg("Backfill retry skipped, the source object was removed")
+			} else {
+				log.Error().Err(err).Str("key", key).Msg("Failed to stat the backfill retry source object")
+				failed = append(failed, key)
+			}
+			continue
+		}
+
+		if skipped, _ := a.copySkip(key, src.Size, src.ContentType); skipped != "" {
+			log.Debug().Str("key", key).Str("skipped", skipped).Msg("Backfill retry skipped")
+			result.Skipped++
+			continue
+		}
+
+		destinations, err := a.backfillDestinations(ctx, *src, destCursors)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Failed to compare the backfill retry object")
+			failed = append(failed, key)
+			continue
+		}
+
+		if len(destinations) == 0 {
+			result.InSync++
+			continue
+		}
+		batch = append(batch, backfillObject{info: *src, destinations: destinations})
+		if len(batch) >= workers {
+			failed = append(failed, a.backfillBatch(ctx, batch, opts, result, workers)...)
+			batch = batch[:0]
+		}
+	}
+	failed = append(failed, a.backfillBatch(ctx, batch, opts, result, workers)...)
+
+	return failed, ctx.Err()
+}
+
+// enqueue or copy a batch of objects, copies use a worker per object, the keys that failed are returned
+func (a *Archiver) backfillBatch(ctx context.Context, batch []backfillObject, opts BackfillOptions, result *BackfillResult, workers int) []string {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	msgTimeout, err := time.ParseDuration(a.MsgTimeout)
+	if err != nil {
+		msgTimeout = 30 * time.Minute
+	}
+
+	var failed []string
+	resultLock := sync.Mutex{}
+	batchWaitGroup := &sync.WaitGroup{}
+	queue := make(chan backfillObject)
+
+	for i := 0; i < workers; i++ {
+		batchWaitGroup.Add(1)
+		go func() {
+			defer batchWaitGroup.Done()
+
+			for object := range queue {
+				bLog := log.With().Str("key", object.info.Key).Strs("destinations", DestinationNames(object.destinations)).Logger()
+
+				enqueued, copied, err := a.backfillObject(ctx, bLog, object, opts, msgTimeout)
+
+				resultLock.Lock()
+				if err != nil {
+					result.Failed++
+					failed = append(failed, object.info.Key)
+				} else if enqueued {
+					result.Enqueued++
+				} else if copied {
+					result.Copied++
+				}
+				resultLock.Unlock()
+			}
+		}()
+	}
+
+	for _, object := range batch {
+		queue <- object
+	}
+	close(queue)
+
+	batchWaitGroup.Wait()
+
+	// an interrupted copy isn't a failure, the batch is listed again
+	if ctx.Err() != nil {
+		return nil
+	}
+	return failed
+}
+
+func (a *Archiver) backfillObject(ctx context.Context, bLog zerolog.Logger, object backfillObject, opts BackfillOptions, msgTimeout time.Duration) (bool, bool, error) {
+	if opts.Subject != "" {
+		if a.DryRun {
+			bLog.Info().Msg("Dry run, enqueue skipped")
+			return false, false, nil
+		}
+
+		// only the destinations that differ copy it again
+		err := a.enqueueEvent(opts.Subject, "s3:ObjectCreated:Put", object.info, "archie-backfill", object.destinations)
+		if err != nil {
+			bLog.Error().Err(err).Msg("Failed to enqueue the backfill event")
+			return false, false, err
+		}
+
+		bLog.Info().Msg("Backfill event enqueued")
+		return true, false, nil
+	}
+
+	transferCtx, transferCancel := context.WithTimeout(ctx, msgTimeout)
+	defer transferCancel()
+
+	srcStat, finished, err, execContext, ack := a.transferObject(transferCtx, bLog, object.info.Key, a.destinationKey(object.info.Key, object.info.LastModified), object.info.ETag, "", a.route(object.info.Key), object.destinations)
+	if err != nil {
+		logS3Error(err, execContext, &bLog)
+		return false, false, err
+	}
+	if ack != Ack {
+		return false, false, nil
+	}
+
+	a.recordManifest(copyManifestEntry(srcStat, object.info.Key, object.info.LastModified, finished))
+
+	bLog.Info().Int64("size", object.info.Size).Str("hSize", size(object.info.Size)).Msg("Backfill transfer complete")
+	return false, true, nil
+}
+
+// checkpoint keys are limited to a few characters so the bucket and prefix are encoded
+func backfillCheckpointKey(bucket string, prefix string) string {
+	return "backfill." + base64.RawURLEncoding.EncodeToString([]byte(bucket+"/"+prefix))
+}
+
+func (a *Archiver) loadBackfillCheckpoint(opts BackfillOptions) backfillCheckpoint {
+	checkpoint := backfillCheckpoint{Prefix: opts.Prefix}
+	if opts.CheckpointKV == nil || opts.Restart {
+		return checkpoint
+	}
+
+	entry, err := opts.CheckpointKV.Get(backfillCheckpointKey(a.SrcBucket, opts.Prefix))
+	if err != nil {
+		if err != nats.ErrKeyNotFound {
+			log.Error().Err(err).Msg("Failed to get the backfill checkpoint, starting from the beginning")
+		}
+		return checkpoint
+	}
+
+	err = json.Unmarshal(entry.Value(), &checkpoint)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal the backfill checkpoint, starting from the beginning")
+		return backfillCheckpoint{Prefix: opts.Prefix}
+	}
+
+	return checkpoint
+}
+
+func (a *Archiver) saveBackfillCheckpoint(opts BackfillOptions, checkpoint backfillCheckpoint) {
+	if opts.CheckpointKV == nil || a.DryRun {
+		return
+	}
+
+	checkpoint.Updated = time.Now().UTC()
+
+	checkpointJSON, err := json.Marshal(checkpoint)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal the backfill checkpoint")
+		return
+	}
+
+	_, err = opts.CheckpointKV.Put(backfillCheckpointKey(a.SrcBucket, opts.Prefix), checkpointJSON)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save the backfill checkpoint")
+	}
+}
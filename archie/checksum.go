@@ -0,0 +1,124 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+	"io"
+	"strings"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch between source and destination")
+
+// the storage classes that charge for early deletes and retrievals, or can't be read at all,
+// recording a checksum would copy their objects again
+var archiveStorageClasses = []string{"ARCHIVE", "COLD", "COLDLINE", "DEEP_ARCHIVE", "GLACIER", "GLACIER_IR"}
+
+// the checksum of the uploaded stream when it's known before the upload, so it's put with the object,
+// only the md5 etag of a single part source object streamed as is
+func (a *Archiver) uploadChecksum(srcStat *client.ObjectInfo, transformed bool) string {
+	if a.ChecksumAlgorithm != client.ChecksumMD5 || transformed {
+		return ""
+	}
+
+	eTag := strings.ToLower(strings.Trim(srcStat.ETag, `"`))
+	if _, err := hex.DecodeString(eTag); err != nil || len(eTag) != 2*md5.Size {
+		return ""
+	}
+	return eTag
+}
+
+// compare the checksum of the source stream with the one the destination reports, or reads back,
+// the checksum recorded at upload is corrected when the source etag turned out not to be its md5,
+// otherwise it's only recorded after the upload when enabled
+func (a *Archiver) verifyChecksum(ctx context.Context, mLog zerolog.Logger, dest *Destination, key string, uploadInfo client.UploadInfo, srcChecksum string, uploadChecksum string, storageClass string) error {
+	record := func() {
+		if uploadChecksum == srcChecksum {
+			return
+		}
+		if uploadChecksum == "" && !a.ChecksumRecord {
+			return
+		}
+		if slices.Contains(archiveStorageClasses, strings.ToUpper(storageClass)) {
+			if uploadChecksum != "" {
+				mLog.Error().Str("destination", dest.Name).Str("storageClass", storageClass).Msg("The checksum recorded at upload is wrong and the archive storage class object isn't copied to correct it")
+			}
+			return
+		}
+		a.recordChecksum(ctx, mLog, dest, key, srcChecksum)
+	}
+
+	destChecksum := uploadInfo.Checksum
+	if destChecksum == "" {
+		if !a.ChecksumReadBack {
+			mLog.Debug().Str("destination", dest.Name).Msgf("The destination can't report a %s checksum, verification skipped", a.ChecksumAlgorithm)
+			a.countChecksumVerificationsMetric(dest.Name, "unverified")
+			record()
+			return nil
+		}
+
+		var err error
+		destChecksum, err = a.readBackChecksum(ctx, dest, key)
+		if err != nil {
+			return fmt.Errorf("failed to read back the destination object for the checksum: %w", err)
+		}
+	}
+
+	if destChecksum != srcChecksum {
+		mLog.Error().
+			Str("destination", dest.Name).
+			Str("algorithm", a.ChecksumAlgorithm).
+			Dict("checksumDiff", zerolog.Dict().
+				Str("source", srcChecksum).
+				Str("destination", destChecksum),
+			).
+			Msg("The source and destination checksums do not match")
+
+		a.countChecksumVerificationsMetric(dest.Name, "mismatch")
+		return fmt.Errorf("%w on %s", errChecksumMismatch, dest.Name)
+	}
+
+	a.countChecksumVerificationsMetric(dest.Name, "match")
+	record()
+	return nil
+}
+
+// hash the destination object when the destination can't report the checksum itself
+func (a *Archiver) readBackChecksum(ctx context.Context, dest *Destination, key string) (string, error) {
+	destObject, err := dest.Client.GetObject(ctx, dest.Bucket, key, client.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	reader := destObject.GetReader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hash, err := client.NewChecksumHash(a.ChecksumAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// the checksum is kept with the object for later audits, the transfer itself already succeeded
+func (a *Archiver) recordChecksum(ctx context.Context, mLog zerolog.Logger, dest *Destination, key string, checksum string) {
+	err := dest.Client.UpdateMetadata(ctx, dest.Bucket, key, map[string]string{
+		client.ChecksumMetadata: fmt.Sprintf("%s:%s", a.ChecksumAlgorithm, checksum),
+	})
+	if err != nil {
+		mLog.Error().Err(err).Str("destination", dest.Name).Msg("Failed to record the checksum in the destination metadata")
+	}
+}
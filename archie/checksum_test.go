@@ -0,0 +1,71 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"github.com/rs/zerolog"
+	"testing"
+)
+
+func TestUploadChecksum(t *testing.T) {
+	tests := []struct {
+		algorithm   string
+		eTag        string
+		transformed bool
+		want        string
+	}{
+		{client.ChecksumMD5, "9E107D9D372BB6826BD81D3542A419D6", false, "9e107d9d372bb6826bd81d3542a419d6"},
+		{client.ChecksumMD5, `"9e107d9d372bb6826bd81d3542a419d6"`, false, "9e107d9d372bb6826bd81d3542a419d6"},
+		// a compressed or encrypted stream isn't the source content
+		{client.ChecksumMD5, "9e107d9d372bb6826bd81d3542a419d6", true, ""},
+		// a multipart etag isn't the md5 of the content
+		{client.ChecksumMD5, "9e107d9d372bb6826bd81d3542a419d6-3", false, ""},
+		{client.ChecksumMD5, "", false, ""},
+		{client.ChecksumSHA256, "9e107d9d372bb6826bd81d3542a419d6", false, ""},
+	}
+
+	for _, test := range tests {
+		a := &Archiver{ChecksumAlgorithm: test.algorithm}
+		got := a.uploadChecksum(&client.ObjectInfo{ETag: test.eTag}, test.transformed)
+		if got != test.want {
+			t.Errorf("%s uploadChecksum(%q, %t) = %q, want %q", test.algorithm, test.eTag, test.transformed, got, test.want)
+		}
+	}
+}
+
+func TestVerifyChecksumRecord(t *testing.T) {
+	tests := []struct {
+		name           string
+		record         bool
+		uploadChecksum string
+		storageClass   string
+		want           string
+	}{
+		{"recording disabled", false, "", "", ""},
+		{"recording enabled", true, "", "", "md5:abc"},
+		{"archive class", true, "", "GLACIER", ""},
+		{"recorded at upload", false, "abc", "", ""},
+		{"wrong at upload", false, "def", "", "md5:abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dest := testDestination(t)
+			putTestObject(t, dest, "key", nil)
+
+			a := &Archiver{ChecksumAlgorithm: client.ChecksumMD5, ChecksumRecord: test.record}
+			err := a.verifyChecksum(context.Background(), zerolog.Nop(), dest, "key", client.UploadInfo{Checksum: "abc"}, "abc", test.uploadChecksum, test.storageClass)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := dest.Client.StatObject(context.Background(), dest.Bucket, "key", client.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := client.MetadataValue(info.UserMetadata, client.ChecksumMetadata); got != test.want {
+				t.Errorf("recorded checksum %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -2,113 +2,381 @@ package archie
 
 import (
 	"archie/client"
+	"archie/compression"
 	"archie/event"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
+	"hash"
+	"io"
+	"net/url"
+	"strconv"
 	"time"
 )
 
 func (a *Archiver) copyObject(ctx context.Context, mLog zerolog.Logger, eventObjKey string, msg *nats.Msg, record event.Record) (error, string, AckType) {
 	metadata, _ := msg.Metadata()
 
-	for _, excludedPathRegexp := range a.ExcludePaths.CopyObject {
-		if excludedPathRegexp.MatchString(eventObjKey) {
-			mLog.Info().
-				Uint64("numDelivered", metadata.NumDelivered).
-				Str("queueDuration", time.Now().Sub(metadata.Timestamp).String()).
-				Str("pattern", excludedPathRegexp.String()).
-				Msg("Excluded path match, copy event skipped")
+	skipped, pattern := a.copySkip(eventObjKey, record.S3.Object.Size, record.S3.Object.ContentType)
+	if skipped != "" {
+		skipLog := mLog.Info().
+			Uint64("numDelivered", metadata.NumDelivered).
+			Str("queueDuration", time.Now().Sub(metadata.Timestamp).String())
+
+		switch skipped {
+		case "EXCLUDED_PATH":
+			skipLog.Str("pattern", pattern).Msg("Excluded path match, copy event skipped")
+		case "NOT_INCLUDED_PATH":
+			skipLog.Msg("No include path match, copy event skipped")
+		default:
+			skipLog.Str("contentType", record.S3.Object.ContentType).Str("filter", skipped).Msg("Object filtered out, copy event skipped")
+		}
+
+		a.observeMessagesTransferNumDeliveredMetric(float64(metadata.NumDelivered))
+		a.observeMessagesTransferQueueDurationMetric(time.Now().Sub(metadata.Timestamp).Seconds())
 
-			a.observeMessagesTransferNumDeliveredMetric(float64(metadata.NumDelivered))
-			a.observeMessagesTransferQueueDurationMetric(time.Now().Sub(metadata.Timestamp).Seconds())
+		return nil, skipped, SkipAck
+	}
+
+	route := a.route(eventObjKey)
+	if route.DeleteOnly {
+		mLog.Info().Str("route", route.Name).Msg("Delete only route, copy event skipped")
+		return nil, "ROUTE_DELETE_ONLY", SkipAck
+	}
 
-			return nil, "EXCLUDED_PATH", SkipAck
+	destinations, done := a.pendingDestinations(mLog, metadata, eventDestinations(msg, route.Destinations))
+	if len(destinations) == 0 {
+		// every destination finished on an earlier delivery
+		return nil, "", Ack
+	}
+
+	destKey := a.destinationKey(eventObjKey, record.EventTime)
+	if destKey != eventObjKey {
+		mLog = mLog.With().Str("destKey", destKey).Logger()
+	}
+
+	start := time.Now()
+	srcStat, finished, err, execContext, ack := a.transferObject(ctx, mLog, eventObjKey, destKey, record.S3.Object.ETag, record.S3.Object.VersionID, route, destinations)
+	if err != nil {
+		if len(finished) > 0 {
+			// only the failed destinations are retried
+			a.saveProgress(mLog, metadata, append(done, finished...))
+		}
+		return err, execContext, ack
+	} else if ack != Ack {
+		if execContext == "SKIPPED_IDENTICAL" {
+			a.clearProgress(mLog, metadata)
+			a.recordManifest(copyManifestEntry(srcStat, eventObjKey, record.EventTime, append(done, finished...)))
 		}
+		return nil, execContext, ack
+	}
+
+	a.clearProgress(mLog, metadata)
+	a.recordManifest(copyManifestEntry(srcStat, eventObjKey, record.EventTime, append(done, finished...)))
+
+	// measure transfer time
+	putElapsed := time.Now().Sub(start)
+
+	// find how much time was spent in the queue
+	totalTime := time.Now().Sub(metadata.Timestamp)
+	queueDuration := totalTime - putElapsed
+
+	mLog.Info().
+		Int64("size", srcStat.Size).
+		Str("hSize", size(srcStat.Size)).
+		Str("transferDuration", putElapsed.String()).
+		Str("rate", rate(srcStat.Size, putElapsed.Seconds())).
+		Uint64("numDelivered", metadata.NumDelivered).
+		Str("queueDuration", queueDuration.String()).
+		Msg("Transfer complete")
+
+	// successful transfer metrics
+	a.observeMessagesTransferNumDeliveredMetric(float64(metadata.NumDelivered))
+	a.observeMessagesTransferQueueDurationMetric(queueDuration.Seconds())
+
+	return nil, "", Ack
+}
+
+func copyManifestEntry(srcStat *client.ObjectInfo, key string, eventTime time.Time, destinations []string) ManifestEntry {
+	return ManifestEntry{
+		Destinations: destinations,
+		ETag:         srcStat.ETag,
+		Key:          key,
+		Op:           ManifestCopy,
+		Size:         srcStat.Size,
+		Time:         eventTime,
+		VersionID:    srcStat.VersionID,
 	}
+}
 
+// transfer the source object to the destinations under the destination key, the names of the
+// destinations that finished are returned even when another destination failed,
+// without a version id the latest version is transferred
+func (a *Archiver) transferObject(ctx context.Context, mLog zerolog.Logger, key string, destKey string, eTag string, versionID string, route *Route, destinations []*Destination) (*client.ObjectInfo, []string, error, string, AckType) {
 	// get src object
-	start := time.Now()
-	srcObject, err := a.SrcClient.GetObject(ctx, a.SrcBucket, eventObjKey)
+	srcObject, err := a.SrcClient.GetObject(ctx, a.SrcBucket, key, client.GetOptions{VersionID: versionID})
 	if err != nil {
-		return err, "Failed to GetObject from the source bucket", Nak
+		return nil, nil, err, "Failed to GetObject from the source bucket", Nak
+	}
+
+	// a failed or skipped transfer lets the source download go
+	if closer, ok := srcObject.GetReader().(io.Closer); ok {
+		defer closer.Close()
 	}
 
 	// get source size, the event's object size wasn't good enough
 	srcStat, err := srcObject.Stat(ctx)
 	if err != nil {
-		if err.Error() == "The specified key does not exist." {
-			// minio error
-			return err, "Failed to Stat the source object", NakThenTerm
-		} else if err.Error() == "storage: object doesn't exist" {
-			// gcs error
-			return err, "Failed to Stat the source object", NakThenTerm
+		if isObjectNotFound(err) {
+			return nil, nil, err, "Failed to Stat the source object", NakThenTerm
 		} else {
-			return err, "Failed to Stat the source object", Nak
+			return nil, nil, err, "Failed to Stat the source object", Nak
 		}
 	}
 
 	if a.WaitForMatchingETag {
-		if srcStat.ETag != record.S3.Object.ETag {
+		if srcStat.ETag != eTag {
 			mLog.Info().
-				Uint64("numDelivered", metadata.NumDelivered).
-				Str("queueDuration", time.Now().Sub(metadata.Timestamp).String()).
 				Dict("etagDiff", zerolog.Dict().
-					Str("event", record.S3.Object.ETag).
+					Str("event", eTag).
 					Str("source", srcStat.ETag),
 				).
 				Msg("The event and source ETag do not match")
 
-			return fmt.Errorf("eTag mismatch between event and source"), "ETAG_MISMATCH", NakThenTerm
+			return srcStat, nil, fmt.Errorf("eTag mismatch between event and source"), "ETAG_MISMATCH", NakThenTerm
+		}
+	}
+
+	// the compressed objects can be stored under the codec's suffix
+	compress := route.compresses(key, srcStat)
+	if compress {
+		destKey += route.keySuffix()
+		mLog = mLog.With().Str("compression", route.Compression).Logger()
+	}
+
+	// a redelivery can find the object already landed on an earlier attempt
+	var finished []string
+	if a.SkipIdentical {
+		destinations, finished = a.skipIdenticalDestinations(ctx, mLog, key, destKey, srcStat, destinations)
+		if len(destinations) == 0 {
+			mLog.Info().
+				Int64("size", srcStat.Size).
+				Str("hSize", size(srcStat.Size)).
+				Msg("Destinations already hold an identical object, transfer skipped")
+
+			return srcStat, finished, nil, "SKIPPED_IDENTICAL", SkipAck
+		}
+	}
+
+	if a.DryRun {
+		mLog.Info().
+			Int64("size", srcStat.Size).
+			Str("hSize", size(srcStat.Size)).
+			Strs("destinations", DestinationNames(destinations)).
+			Msg("Dry run, transfer skipped")
+
+		return srcStat, nil, nil, "DRY_RUN", SkipAck
+	}
+
+	// dest object options
+	envelope := map[string]string{}
+	var uploadChecksum string
+	putOpts := func(dest *Destination) client.PutOptions {
+		opts := client.PutOptions{
+			Checksum:        a.ChecksumAlgorithm,
+			ContentType:     srcStat.ContentType,
+			LegalHold:       route.LegalHold,
+			NumThreads:      dest.Threads,
+			PartSize:        1024 * 1024 * dest.PartSize,
+			RetainUntil:     route.retainUntil(),
+			RetentionMode:   route.RetentionMode,
+			SourceVersionID: srcStat.VersionID,
+			StorageClass:    route.storageClass(dest),
+		}
+
+		if eTag != "" {
+			opts.ETag = eTag
+		}
+
+		a.preserveMetadata(&opts, srcStat)
+
+		// the compression and encryption envelope goes with the stored object
+		if len(envelope) > 0 || len(a.KeyRules) > 0 || uploadChecksum != "" {
+			if opts.UserMetadata == nil {
+				opts.UserMetadata = map[string]string{}
+			}
+			for name, value := range envelope {
+				opts.UserMetadata[name] = value
+			}
+		}
+
+		// a checksum known up front is put with the object instead of copying it again after the upload
+		if uploadChecksum != "" {
+			opts.UserMetadata[client.ChecksumMetadata] = fmt.Sprintf("%s:%s", a.ChecksumAlgorithm, uploadChecksum)
+		}
+
+		// metadata values are limited to ascii, the key is recorded even when no rule matched
+		// since a stripped prefix can't be told apart from a key that never had it
+		if len(a.KeyRules) > 0 {
+			opts.UserMetadata[client.SourceKeyMetadata] = url.PathEscape(key)
+		}
+
+		return opts
+	}
+
+	// a server-side copy can't compress
+	if !compress && hasServerSideCopy(destinations) {
+		var copied []string
+		destinations, copied = a.copyServerSide(ctx, mLog, key, destKey, srcStat, destinations, putOpts)
+		finished = append(finished, copied...)
+
+		// nothing is left to stream
+		if len(destinations) == 0 {
+			return srcStat, finished, nil, "", Ack
 		}
 	}
 
 	mLog.Info().
 		Int64("size", srcStat.Size).
 		Str("hSize", size(srcStat.Size)).
+		Strs("destinations", DestinationNames(destinations)).
 		Msg("Transfer started")
 
-	// put dest object
-	destPartSizeBytes := 1024 * 1024 * a.DestPartSize
-	putOpts := client.PutOptions{
-		ContentType: srcStat.ContentType,
-		NumThreads:  a.DestThreads,
-		PartSize:    destPartSizeBytes,
+	// compress then encrypt once for every destination, the compressed size isn't known up front
+	reader := srcObject.GetReader()
+	uploadSize := srcStat.Size
+	var compressed *compression.Reader
+	if compress {
+		compressed, err = compression.Compress(reader, route.Compression)
+		if err != nil {
+			return srcStat, nil, err, "Failed to setup the compression", Nak
+		}
+		defer compressed.Close()
+
+		reader, uploadSize = compressed, -1
+		envelope[compression.CodecMetadata] = route.Compression
+	}
+	if a.Encryption != nil {
+		var encryptionEnvelope map[string]string
+		reader, uploadSize, encryptionEnvelope, err = a.Encryption.Encrypt(reader, uploadSize)
+		if err != nil {
+			return srcStat, nil, err, "Failed to setup the encryption", Nak
+		}
+		for name, value := range encryptionEnvelope {
+			envelope[name] = value
+		}
+	}
+	if len(envelope) > 0 {
+		envelope[client.OriginalSizeMetadata] = strconv.FormatInt(srcStat.Size, 10)
 	}
 
-	if record.S3.Object.ETag != "" {
-		putOpts.ETag = record.S3.Object.ETag
+	// hash the stream once for every destination, an encrypted one is hashed as it's stored
+	var srcHash hash.Hash
+	if a.ChecksumAlgorithm != "" {
+		srcHash, err = client.NewChecksumHash(a.ChecksumAlgorithm)
+		if err != nil {
+			return srcStat, nil, err, "Failed to setup the checksum", Nak
+		}
+		reader = io.TeeReader(reader, srcHash)
 	}
+	uploadChecksum = a.uploadChecksum(srcStat, len(envelope) > 0)
 
-	start = time.Now()
-	_, err = a.DestClient.PutObject(ctx, a.DestBucket, eventObjKey, srcObject.GetReader(), srcStat.Size, putOpts)
-	if err != nil {
-		return err, "Failed to PutObject to the destination bucket", Nak
+	// put dest objects
+	results := a.putDestinations(ctx, destinations, destKey, reader, uploadSize, putOpts)
+
+	var srcChecksum string
+	if srcHash != nil {
+		srcChecksum = hex.EncodeToString(srcHash.Sum(nil))
 	}
 
-	// measure transfer time
-	putElapsed := time.Now().Sub(start)
+	var putErr error
+	for _, result := range results {
+		if result.err == nil && srcHash != nil {
+			result.err = a.verifyChecksum(ctx, mLog, result.dest, destKey, result.info, srcChecksum, uploadChecksum, route.storageClass(result.dest))
+		}
+		if result.err == nil {
+			result.err = a.indexKey(result.dest, key, destKey)
+		}
+		if result.err != nil {
+			if len(results) > 1 {
+				mLog.Error().Err(result.err).Str("destination", result.dest.Name).Msg("Transfer failed")
+			}
+			if putErr == nil {
+				putErr = result.err
+			}
+			continue
+		}
 
-	// find how much time was spent in the queue
-	totalTime := time.Now().Sub(metadata.Timestamp)
-	queueDuration := totalTime - putElapsed
+		finished = append(finished, result.dest.Name)
 
-	mLog.Info().
-		Int64("size", srcStat.Size).
-		Str("hSize", size(srcStat.Size)).
-		Str("transferDuration", putElapsed.String()).
-		Str("rate", rate(srcStat.Size, putElapsed.Seconds())).
-		Uint64("numDelivered", metadata.NumDelivered).
-		Str("queueDuration", queueDuration.String()).
-		Msg("Transfer complete")
+		if len(results) > 1 {
+			mLog.Info().
+				Str("destination", result.dest.Name).
+				Str("transferDuration", result.elapsed.String()).
+				Str("rate", rate(srcStat.Size, result.elapsed.Seconds())).
+				Msg("Destination transfer complete")
+		}
 
-	// successful transfer metrics
-	a.observeMessagesTransferDurationMetric(putElapsed.Seconds())
-	a.observeMessagesTransferRateMetric(float64(srcStat.Size) / putElapsed.Seconds())
-	a.observeMessagesTransferSizeMetric(float64(srcStat.Size))
-	a.observeMessagesTransferNumDeliveredMetric(float64(metadata.NumDelivered))
-	a.observeMessagesTransferQueueDurationMetric(queueDuration.Seconds())
+		storageClass := route.storageClass(result.dest)
+		a.observeMessagesTransferDurationMetric(result.dest.Name, storageClass, result.elapsed.Seconds())
+		a.observeMessagesTransferRateMetric(result.dest.Name, storageClass, float64(srcStat.Size)/result.elapsed.Seconds())
+		a.observeMessagesTransferSizeMetric(result.dest.Name, storageClass, float64(srcStat.Size))
+	}
 
-	return nil, "", Ack
+	if compressed != nil && putErr == nil {
+		mLog.Info().
+			Int64("size", srcStat.Size).
+			Int64("compressedSize", compressed.Size()).
+			Str("hCompressedSize", size(compressed.Size())).
+			Msg("Object compressed")
+	}
+
+	if errors.Is(putErr, errChecksumMismatch) {
+		return srcStat, finished, putErr, "CHECKSUM_MISMATCH", Nak
+	} else if putErr != nil {
+		return srcStat, finished, putErr, "Failed to PutObject to the destination bucket", Nak
+	}
+
+	return srcStat, finished, nil, "", Ack
+}
+
+// split the destinations into the ones that need the transfer and the names of the ones
+// that already hold an object with the same size and ETag
+func (a *Archiver) skipIdenticalDestinations(ctx context.Context, mLog zerolog.Logger, key string, destKey string, srcStat *client.ObjectInfo, destinations []*Destination) ([]*Destination, []string) {
+	var pending []*Destination
+	var identical []string
+
+	for _, dest := range destinations {
+		destStat, err := dest.Client.StatObject(ctx, dest.Bucket, destKey, client.GetOptions{})
+		if err != nil {
+			if !isObjectNotFound(err) {
+				mLog.Debug().Err(err).Str("destination", dest.Name).Msg("Failed to Stat the destination object")
+			}
+			pending = append(pending, dest)
+			continue
+		}
+
+		if !a.identicalObject(srcStat, destStat) {
+			pending = append(pending, dest)
+			continue
+		}
+
+		// the object may have landed on an attempt that failed to index it
+		err = a.indexKey(dest, key, destKey)
+		if err != nil {
+			mLog.Error().Err(err).Str("destination", dest.Name).Msg("Failed to index the identical destination object")
+			pending = append(pending, dest)
+			continue
+		}
+
+		if len(destinations) > 1 {
+			mLog.Info().Str("destination", dest.Name).Msg("Destination already holds an identical object")
+		}
+		identical = append(identical, dest.Name)
+	}
+
+	return pending, identical
 }
@@ -0,0 +1,84 @@
+package archie
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"strconv"
+	"strings"
+)
+
+// dead letter message headers
+const (
+	deadLetterConsumerHeader       = "Archie-Consumer"
+	deadLetterErrorCodeHeader      = "Archie-Error-Code"
+	deadLetterNumDeliveredHeader   = "Archie-Num-Delivered"
+	deadLetterReasonHeader         = "Archie-Term-Reason"
+	deadLetterStreamHeader         = "Archie-Stream"
+	deadLetterStreamSequenceHeader = "Archie-Stream-Sequence"
+	deadLetterSubjectHeader        = "Archie-Subject"
+)
+
+// publish the message to the dead letter subject then terminate it,
+// if the publish fails the message is NAck'd so it isn't lost
+func (a *Archiver) sendDeadLetterThenTerm(msg *nats.Msg, mLog *zerolog.Logger, reason string, errCode string) error {
+	if a.DeadLetterSubject != "" {
+		err := a.publishDeadLetter(msg, reason, errCode)
+		if err != nil {
+			mLog.Error().Err(err).Str("deadLetterSubject", a.DeadLetterSubject).Msg("Failed to publish to the dead letter subject")
+			sendNakSignal(msg, mLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
+			return err
+		}
+		mLog.Info().Str("deadLetterSubject", a.DeadLetterSubject).Msg("Published to the dead letter subject")
+	}
+
+	return sendTermSignal(msg, mLog)
+}
+
+func (a *Archiver) publishDeadLetter(msg *nats.Msg, reason string, errCode string) error {
+	metadata, err := msg.Metadata()
+	if err != nil {
+		return err
+	}
+
+	deadLetterMsg := nats.NewMsg(a.DeadLetterSubject)
+	deadLetterMsg.Data = msg.Data
+
+	// keep the original headers and add the termination details
+	copyHeaders(msg, deadLetterMsg)
+	deadLetterMsg.Header.Set(deadLetterReasonHeader, reason)
+	deadLetterMsg.Header.Set(deadLetterErrorCodeHeader, errCode)
+	deadLetterMsg.Header.Set(deadLetterNumDeliveredHeader, strconv.FormatUint(metadata.NumDelivered, 10))
+	deadLetterMsg.Header.Set(deadLetterStreamHeader, metadata.Stream)
+	deadLetterMsg.Header.Set(deadLetterStreamSequenceHeader, strconv.FormatUint(metadata.Sequence.Stream, 10))
+	deadLetterMsg.Header.Set(deadLetterConsumerHeader, metadata.Consumer)
+	deadLetterMsg.Header.Set(deadLetterSubjectHeader, msg.Subject)
+
+	// a redelivery after a failed Term shouldn't dead letter the message twice
+	deadLetterMsg.Header.Set(nats.MsgIdHdr, fmt.Sprintf("%s.%d", metadata.Stream, metadata.Sequence.Stream))
+
+	_, err = a.JetStream.PublishMsg(deadLetterMsg)
+	return err
+}
+
+// copy the headers to another message, the nats headers are dropped
+// since they would apply to the new publish
+func copyHeaders(from *nats.Msg, to *nats.Msg, skipPrefixes ...string) {
+	skipPrefixes = append(skipPrefixes, "Nats-")
+
+	for name, values := range from.Header {
+		skip := false
+		for _, prefix := range skipPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				skip = true
+			}
+		}
+		if skip {
+			continue
+		}
+
+		for _, value := range values {
+			to.Header.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a replica trusts its copy of the shared breaker state
+const deleteBreakerSyncInterval = 5 * time.Second
+
+const deleteBreakerStateKey = "delete-breaker"
+
+var errDeleteBreakerOpen = errors.New("the delete circuit breaker is open, resume it through the admin api")
+
+// DeleteBreaker pauses the deletes when more than MaxDeletes arrive within the Window, or more than
+// MaxPercent of the objects under a prefix of PrefixDepth path segments, until it's resumed through
+// the admin api, each replica counts its own deletes while the open state is shared through the StateKV
+type DeleteBreaker struct {
+	AdminPort         int
+	AdminToken        string
+	MaxDeletes        int
+	MaxPercent        float64
+	PercentMinDeletes int
+	PrefixDepth       int
+	StateKV           nats.KeyValue
+	Window            time.Duration
+
+	lock     sync.Mutex
+	approved int
+	deletes  []time.Time
+	prefixes map[string]*breakerPrefix
+	state    deleteBreakerState
+	synced   time.Time
+}
+
+type breakerPrefix struct {
+	counted time.Time
+	deletes []time.Time
+	objects int
+}
+
+// a trip records the count of deletes that tripped it, the resume after it approves that many of the paused deletes
+type deleteBreakerState struct {
+	Approved  int       `json:"approved,omitempty"`
+	Deletes   int       `json:"deletes,omitempty"`
+	Open      bool      `json:"open"`
+	Reason    string    `json:"reason,omitempty"`
+	ResumedAt time.Time `json:"resumedAt,omitempty"`
+	TrippedAt time.Time `json:"trippedAt,omitempty"`
+}
+
+// count the delete and check the breaker, an error when the delete has to wait for a resume
+func (a *Archiver) checkDeleteBreaker(ctx context.Context, mLog zerolog.Logger, key string, route *Route) error {
+	b := a.DeleteBreaker
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	b.sync(mLog)
+	open := b.state.Open
+	// a resume approves as many of the paused deletes as tripped the breaker, the ones after them are counted
+	approved := !open && b.approved > 0
+	if approved {
+		b.approved--
+	}
+	b.lock.Unlock()
+
+	if open {
+		return errDeleteBreakerOpen
+	} else if approved {
+		return nil
+	}
+
+	var prefix string
+	var objects int
+	if b.MaxPercent > 0 {
+		prefix = keyPrefix(key, b.PrefixDepth)
+		objects = b.prefixObjects(ctx, mLog, prefix, route)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.deletes = append(withinWindow(b.deletes, now.Add(-b.Window)), now)
+	if b.MaxDeletes > 0 && len(b.deletes) > b.MaxDeletes {
+		return b.trip(mLog, len(b.deletes), fmt.Sprintf("%d deletes within %s", len(b.deletes), b.Window))
+	}
+
+	if b.MaxPercent > 0 && objects > 0 {
+		// a resume while counting dropped the prefixes
+		p, ok := b.prefixes[prefix]
+		if !ok {
+			return nil
+		}
+		p.deletes = append(withinWindow(p.deletes, now.Add(-b.Window)), now)
+
+		// the quiet prefixes are forgotten so they're counted again on their next delete
+		for name, other := range b.prefixes {
+			if name != prefix && now.Sub(other.counted) > b.Window && len(withinWindow(other.deletes, now.Add(-b.Window))) == 0 {
+				delete(b.prefixes, name)
+			}
+		}
+
+		percent := float64(len(p.deletes)) / float64(objects) * 100
+		if len(p.deletes) >= b.PercentMinDeletes && percent > b.MaxPercent {
+			return b.trip(mLog, len(p.deletes), fmt.Sprintf("%.1f%% of the %d objects under %s deleted within %s", percent, objects, prefix, b.Window))
+		}
+	}
+
+	return nil
+}
+
+// the prefix's object count is taken from the route's first destination since the source already lost them,
+// it's counted again once per window
+func (b *DeleteBreaker) prefixObjects(ctx context.Context, mLog zerolog.Logger, prefix string, route *Route) int {
+	b.lock.Lock()
+	if b.prefixes == nil {
+		b.prefixes = map[string]*breakerPrefix{}
+	}
+	p, ok := b.prefixes[prefix]
+	if !ok {
+		p = &breakerPrefix{}
+		b.prefixes[prefix] = p
+	}
+	if time.Since(p.counted) < b.Window {
+		objects := p.objects
+		b.lock.Unlock()
+		return objects
+	}
+	b.lock.Unlock()
+
+	if len(route.Destinations) == 0 {
+		return 0
+	}
+	dest := route.Destinations[0]
+
+	// canceling the listing stops its goroutine on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	objects := 0
+	for info := range dest.Client.ListObjects(listCtx, dest.Bucket, prefix, client.ListOptions{}) {
+		if info.Err != nil {
+			mLog.Error().Err(info.Err).Str("destination", dest.Name).Str("prefix", prefix).Msg("Failed to count the prefix objects for the delete breaker")
+			return 0
+		}
+		objects++
+	}
+
+	b.lock.Lock()
+	p.counted = time.Now()
+	p.objects = objects
+	b.lock.Unlock()
+
+	return objects
+}
+
+func (b *DeleteBreaker) trip(mLog zerolog.Logger, deletes int, reason string) error {
+	b.state = deleteBreakerState{Deletes: deletes, Open: true, Reason: reason, ResumedAt: b.state.ResumedAt, TrippedAt: time.Now().UTC()}
+	b.save(mLog)
+	deleteBreakerOpenMetric.Set(1)
+
+	mLog.Error().Str("reason", reason).Msg("Delete circuit breaker tripped, deletes are paused until resumed")
+	return errDeleteBreakerOpen
+}
+
+func (b *DeleteBreaker) resume(mLog zerolog.Logger) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.state = deleteBreakerState{Approved: b.state.Deletes, ResumedAt: time.Now().UTC()}
+	b.approved = b.state.Approved
+	b.deletes = nil
+	b.prefixes = nil
+	b.save(mLog)
+	deleteBreakerOpenMetric.Set(0)
+
+	mLog.Info().Int("approved", b.approved).Msg("Delete circuit breaker resumed")
+}
+
+// pick up a trip or resume from the other replicas
+func (b *DeleteBreaker) sync(mLog zerolog.Logger) {
+	if b.StateKV == nil || time.Since(b.synced) < deleteBreakerSyncInterval {
+		return
+	}
+	b.synced = time.Now()
+
+	entry, err := b.StateKV.Get(deleteBreakerStateKey)
+	if err != nil {
+		if err != nats.ErrKeyNotFound {
+			mLog.Error().Err(err).Msg("Failed to get the delete breaker state")
+		}
+		return
+	}
+
+	var state deleteBreakerState
+	err = json.Unmarshal(entry.Value(), &state)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to unmarshal the delete breaker state")
+		return
+	}
+
+	if state.ResumedAt.After(b.state.ResumedAt) {
+		b.approved = state.Approved
+		b.deletes = nil
+		b.prefixes = nil
+	}
+	b.state = state
+
+	if state.Open {
+		deleteBreakerOpenMetric.Set(1)
+	} else {
+		deleteBreakerOpenMetric.Set(0)
+	}
+}
+
+func (b *DeleteBreaker) save(mLog zerolog.Logger) {
+	if b.StateKV == nil {
+		return
+	}
+
+	stateJSON, err := json.Marshal(b.state)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to marshal the delete breaker state")
+		return
+	}
+
+	_, err = b.StateKV.Put(deleteBreakerStateKey, stateJSON)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to save the delete breaker state")
+	}
+	b.synced = time.Now()
+}
+
+// StartDeleteBreakerAPI serves the breaker state on /admin/delete-breaker and resumes it with a POST to
+// /admin/delete-breaker/resume on its own port, both need the admin token as a bearer token
+func (a *Archiver) StartDeleteBreakerAPI() *http.Server {
+	b := a.DeleteBreaker
+
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(b.AdminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	// the admin api isn't served by the metrics or health check servers
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/delete-breaker", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+
+		b.lock.Lock()
+		b.sync(log.Logger)
+		state := b.state
+		b.lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state)
+	})
+
+	mux.HandleFunc("/admin/delete-breaker/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(w, r) {
+			return
+		}
+
+		b.resume(log.With().Str("remoteAddr", r.RemoteAddr).Logger())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", b.AdminPort), Handler: mux}
+
+	go func() {
+		defer func() {
+			log.Trace().Msg("Deferred delete breaker admin api wait group context canceled")
+			a.WaitGroup.Done()
+		}()
+		a.WaitGroup.Add(1)
+
+		// blocking
+		err := srv.ListenAndServe()
+		// if not a graceful close
+		if err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Failed to start the delete breaker admin api server")
+		}
+	}()
+
+	log.Info().Msgf("Started HTTP server for the delete breaker admin api listening on :%d", b.AdminPort)
+
+	return srv
+}
+
+// the first depth path segments of the key, the whole bucket with a zero depth
+func keyPrefix(key string, depth int) string {
+	if depth <= 0 {
+		return ""
+	}
+
+	segments := strings.SplitAfter(key, "/")
+	if len(segments) <= depth {
+		// the key itself isn't a prefix
+		return strings.Join(segments[:len(segments)-1], "")
+	}
+	return strings.Join(segments[:depth], "")
+}
+
+func withinWindow(times []time.Time, start time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(start) {
+		i++
+	}
+	return times[i:]
+}
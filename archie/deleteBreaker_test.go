@@ -0,0 +1,97 @@
+package archie
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+func TestDeleteBreakerApproval(t *testing.T) {
+	b := &DeleteBreaker{MaxDeletes: 2, Window: time.Minute}
+	a := &Archiver{DeleteBreaker: b}
+	ctx := context.Background()
+	mLog := zerolog.Nop()
+
+	check := func() error {
+		return a.checkDeleteBreaker(ctx, mLog, "key", &Route{})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+	if err := check(); err != errDeleteBreakerOpen {
+		t.Fatalf("the third delete didn't trip the breaker: %v", err)
+	}
+	if b.state.Deletes != 3 {
+		t.Fatalf("tripped by %d deletes, want 3", b.state.Deletes)
+	}
+
+	// the paused deletes wait for the resume
+	if err := check(); err != errDeleteBreakerOpen {
+		t.Fatalf("an open breaker let a delete through: %v", err)
+	}
+
+	b.resume(mLog)
+	if b.state.Open || b.state.Approved != 3 {
+		t.Fatalf("resumed state %+v, want 3 approved", b.state)
+	}
+
+	// the approved deletes aren't counted
+	for i := 0; i < 3; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("approved delete %d: %v", i, err)
+		}
+	}
+	if len(b.deletes) != 0 {
+		t.Fatalf("%d approved deletes were counted", len(b.deletes))
+	}
+
+	// the deletes after the approved ones trip it again
+	for i := 0; i < 2; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("delete %d after the approval: %v", i, err)
+		}
+	}
+	if err := check(); err != errDeleteBreakerOpen {
+		t.Fatalf("the deletes after the approval didn't trip the breaker: %v", err)
+	}
+}
+
+func TestDeleteBreakerResumeWithoutTrip(t *testing.T) {
+	b := &DeleteBreaker{MaxDeletes: 1, Window: time.Minute}
+	a := &Archiver{DeleteBreaker: b}
+	mLog := zerolog.Nop()
+
+	// a resume of a closed breaker approves nothing
+	b.resume(mLog)
+
+	if err := a.checkDeleteBreaker(context.Background(), mLog, "key", &Route{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.checkDeleteBreaker(context.Background(), mLog, "key", &Route{}); err != errDeleteBreakerOpen {
+		t.Fatalf("a resume without a trip approved a delete: %v", err)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key   string
+		depth int
+		want  string
+	}{
+		{"a/b/c.txt", 0, ""},
+		{"a/b/c.txt", 1, "a/"},
+		{"a/b/c.txt", 2, "a/b/"},
+		{"a/b/c.txt", 3, "a/b/"},
+		{"c.txt", 1, ""},
+	}
+
+	for _, test := range tests {
+		if got := keyPrefix(test.key, test.depth); got != test.want {
+			t.Errorf("keyPrefix(%q, %d) = %q, want %q", test.key, test.depth, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DeletePolicyDelay     = "delay"
+	DeletePolicyIgnore    = "ignore"
+	DeletePolicyMirror    = "mirror"
+	DeletePolicyTombstone = "tombstone"
+)
+
+// how often the tombstones past their expiry are purged
+const tombstonePurgeInterval = time.Hour
+
+// DeletePolicy decides what a source delete does to the destinations, mirror removes the objects right away,
+// ignore keeps them, delay holds the delete for a grace period and tombstone moves them under a prefix
+type DeletePolicy struct {
+	Delay           time.Duration
+	Mode            string
+	TombstoneExpiry time.Duration
+	TombstonePrefix string
+}
+
+// the deletes held by this replica and when their grace period ends
+type pendingDeletes struct {
+	lock sync.Mutex
+	due  map[string]time.Time
+}
+
+// nak the delete until its grace period since it was queued ends, false once it's due
+func (a *Archiver) delayDelete(mLog zerolog.Logger, msg *nats.Msg, metadata *nats.MsgMetadata) bool {
+	due := metadata.Timestamp.Add(a.DeletePolicy.Delay)
+	remaining := time.Until(due)
+
+	a.pendingDeletes.lock.Lock()
+	defer a.pendingDeletes.lock.Unlock()
+
+	if a.pendingDeletes.due == nil {
+		a.pendingDeletes.due = map[string]time.Time{}
+	}
+
+	if remaining <= 0 {
+		delete(a.pendingDeletes.due, progressKey(metadata))
+		a.setDeletesPendingMetric()
+		return false
+	}
+
+	mLog.Info().Str("due", due.Format(time.RFC3339)).Msgf("Delete held for the %s grace period", a.DeletePolicy.Delay)
+	sendDelaySignal(msg, &mLog, remaining)
+
+	a.pendingDeletes.due[progressKey(metadata)] = due
+	a.setDeletesPendingMetric()
+	return true
+}
+
+// a delete redelivered to another replica is never released here so the ones past due are dropped
+func (a *Archiver) setDeletesPendingMetric() {
+	now := time.Now()
+	for key, due := range a.pendingDeletes.due {
+		if due.Before(now) {
+			delete(a.pendingDeletes.due, key)
+		}
+	}
+	deletesPendingMetric.Set(float64(len(a.pendingDeletes.due)))
+}
+
+// move the destination object under the tombstone prefix, the copy's time starts its expiry
+func (a *Archiver) tombstoneObject(ctx context.Context, mLog zerolog.Logger, dest *Destination, key string) error {
+	destStat, err := dest.Client.StatObject(ctx, dest.Bucket, key, client.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	tombstoneKey := a.DeletePolicy.TombstonePrefix + key
+
+	// the object is kept as it is, archie's own metadata is set again from the stat
+	opts := client.PutOptions{
+		CacheControl:       destStat.CacheControl,
+		ContentDisposition: destStat.ContentDisposition,
+		ContentEncoding:    destStat.ContentEncoding,
+		ContentLanguage:    destStat.ContentLanguage,
+		ContentType:        destStat.ContentType,
+		ETag:               destStat.ETag,
+		NumThreads:         dest.Threads,
+		PartSize:           1024 * 1024 * dest.PartSize,
+		SourceVersionID:    client.MetadataValue(destStat.UserMetadata, client.SourceVersionMetadata),
+		StorageClass:       destStat.StorageClass,
+		Tags:               destStat.Tags,
+		UserMetadata:       destStat.UserMetadata,
+	}
+
+	_, err = dest.Client.CopyObject(ctx, dest.Bucket, key, dest.Bucket, tombstoneKey, opts)
+	if errors.Is(err, client.ErrCopyNotSupported) {
+		err = streamObject(ctx, dest, key, tombstoneKey, destStat.Size, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = dest.Client.RemoveObject(ctx, dest.Bucket, key, client.RemoveOptions{})
+	if err != nil {
+		return err
+	}
+
+	mLog.Info().Str("destination", dest.Name).Str("tombstoneKey", tombstoneKey).Msg("Destination object moved to the tombstones")
+	a.countTombstonesMetric(dest.Name)
+	return nil
+}
+
+// copy an object within a destination that can't copy server-side
+func streamObject(ctx context.Context, dest *Destination, key string, destKey string, size int64, opts client.PutOptions) error {
+	object, err := dest.Client.GetObject(ctx, dest.Bucket, key, client.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	reader := object.GetReader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	_, err = dest.Client.PutObject(ctx, dest.Bucket, destKey, reader, size, opts)
+	return err
+}
+
+func (a *Archiver) isTombstone(key string) bool {
+	return a.DeletePolicy.Mode == DeletePolicyTombstone && strings.HasPrefix(key, a.DeletePolicy.TombstonePrefix)
+}
+
+// StartTombstonePurger periodically removes the tombstones past their expiry from every destination
+// until the context is canceled, every replica purges so a missing object isn't an error
+func (a *Archiver) StartTombstonePurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tombstonePurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			for _, dest := range a.Destinations {
+				a.purgeTombstones(ctx, dest)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Info().Msgf("Started the tombstone purger with a %s expiry", a.DeletePolicy.TombstoneExpiry)
+}
+
+func (a *Archiver) purgeTombstones(ctx context.Context, dest *Destination) {
+	// canceling the listing stops its goroutine on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	purged := 0
+	for info := range dest.Client.ListObjects(listCtx, dest.Bucket, a.DeletePolicy.TombstonePrefix, client.ListOptions{}) {
+		if info.Err != nil {
+			log.Error().Err(info.Err).Str("destination", dest.Name).Msg("Failed to list the tombstones")
+			return
+		}
+		if time.Since(info.LastModified) < a.DeletePolicy.TombstoneExpiry {
+			continue
+		}
+
+		err := dest.Client.RemoveObject(ctx, dest.Bucket, info.Key, client.RemoveOptions{})
+		if err != nil && !isObjectNotFound(err) {
+			log.Error().Err(err).Str("destination", dest.Name).Str("key", info.Key).Msg("Failed to purge the tombstone")
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		log.Info().Str("destination", dest.Name).Int("purged", purged).Msg("Expired tombstones purged")
+	}
+}
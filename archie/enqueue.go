@@ -0,0 +1,93 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/event"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/exp/slices"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the header scoping an enqueued event to some of its route's destinations, by name
+const destinationsHeader = "Archie-Destinations"
+
+// publish a synthetic minio event for the object so the archie workers copy or remove it,
+// with destinations the event only goes to those, otherwise to every destination of its route
+func (a *Archiver) enqueueEvent(subject string, eventName string, info client.ObjectInfo, sourceHost string, destinations []*Destination) error {
+	// the object's own time keeps the date partitioned destination keys in line with its original event
+	eventTime := info.LastModified
+	if eventTime.IsZero() {
+		eventTime = time.Now()
+	}
+
+	syntheticEvent := event.Minio{
+		EventName: eventName,
+		Key:       fmt.Sprintf("%s/%s", a.SrcBucket, info.Key),
+		Records: []event.Record{
+			{
+				EventVersion: "2.0",
+				EventSource:  "minio:s3",
+				EventTime:    eventTime.UTC(),
+				EventName:    eventName,
+				S3: event.S3{
+					S3SchemaVersion: "1.0",
+					Bucket: event.Bucket{
+						Name: a.SrcBucket,
+					},
+					Object: event.Object{
+						// minio escapes the key in the record
+						Key:         url.QueryEscape(info.Key),
+						Size:        info.Size,
+						ETag:        info.ETag,
+						ContentType: info.ContentType,
+					},
+				},
+				Source: event.Source{
+					Host:      sourceHost,
+					UserAgent: sourceHost,
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(syntheticEvent)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+
+	// the stream drops a duplicate of the same object version within its duplicate window
+	msgID := fmt.Sprintf("%s.%s.%s/%s.%s", sourceHost, eventName, a.SrcBucket, info.Key, info.ETag)
+	if len(destinations) > 0 {
+		names := strings.Join(DestinationNames(destinations), ",")
+		msg.Header.Set(destinationsHeader, names)
+		msgID += "." + names
+	}
+	msg.Header.Set(nats.MsgIdHdr, msgID)
+
+	_, err = a.JetStream.PublishMsg(msg)
+	return err
+}
+
+// the route destinations an event is scoped to by its header, all of them without one
+func eventDestinations(msg *nats.Msg, destinations []*Destination) []*Destination {
+	header := msg.Header.Get(destinationsHeader)
+	if header == "" {
+		return destinations
+	}
+
+	names := strings.Split(header, ",")
+	var scoped []*Destination
+	for _, dest := range destinations {
+		if slices.Contains(names, dest.Name) {
+			scoped = append(scoped, dest)
+		}
+	}
+	return scoped
+}
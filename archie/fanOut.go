@@ -0,0 +1,108 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+type putResult struct {
+	dest    *Destination
+	elapsed time.Duration
+	err     error
+	info    client.UploadInfo
+}
+
+// put the object to every destination while reading the source only once
+func (a *Archiver) putDestinations(
+	ctx context.Context,
+	destinations []*Destination,
+	key string,
+	reader io.Reader,
+	objectSize int64,
+	putOpts func(dest *Destination) client.PutOptions,
+) []putResult {
+	results := make([]putResult, len(destinations))
+
+	if len(destinations) == 1 {
+		dest := destinations[0]
+		start := time.Now()
+		info, err := dest.Client.PutObject(ctx, dest.Bucket, key, reader, objectSize, putOpts(dest))
+		results[0] = putResult{dest: dest, elapsed: time.Now().Sub(start), err: err, info: info}
+		return results
+	}
+
+	writers := make([]*io.PipeWriter, len(destinations))
+	putWaitGroup := &sync.WaitGroup{}
+
+	for i, dest := range destinations {
+		pipeReader, pipeWriter := io.Pipe()
+		writers[i] = pipeWriter
+
+		putWaitGroup.Add(1)
+		go func(i int, dest *Destination) {
+			defer putWaitGroup.Done()
+
+			start := time.Now()
+			info, err := dest.Client.PutObject(ctx, dest.Bucket, key, pipeReader, objectSize, putOpts(dest))
+			// unblock the fan-out if this destination stopped reading early
+			if err != nil {
+				_ = pipeReader.CloseWithError(err)
+			} else {
+				_ = pipeReader.Close()
+			}
+			results[i] = putResult{dest: dest, elapsed: time.Now().Sub(start), err: err, info: info}
+		}(i, dest)
+	}
+
+	// a source read error is passed on to every destination, nil closes them normally
+	readErr := fanOut(reader, writers)
+	for _, writer := range writers {
+		_ = writer.CloseWithError(readErr)
+	}
+
+	putWaitGroup.Wait()
+
+	return results
+}
+
+// copy the reader to all the writers, a writer that fails is dropped so the others can finish
+func fanOut(reader io.Reader, writers []*io.PipeWriter) error {
+	active := make([]bool, len(writers))
+	for i := range active {
+		active[i] = true
+	}
+
+	buffer := make([]byte, 1024*1024)
+
+	for {
+		n, readErr := reader.Read(buffer)
+		if n > 0 {
+			remaining := 0
+			for i, writer := range writers {
+				if !active[i] {
+					continue
+				}
+				_, err := writer.Write(buffer[:n])
+				if err != nil {
+					active[i] = false
+					continue
+				}
+				remaining++
+			}
+
+			if remaining == 0 {
+				return errors.New("all destinations stopped reading the source object")
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+}
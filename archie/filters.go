@@ -0,0 +1,85 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"go.arsenm.dev/pcre"
+	"path"
+	"strings"
+)
+
+// ObjectFilters leave out the copy events by the event's object size and content type,
+// a zero size doesn't limit and the content types are glob patterns like image/*
+type ObjectFilters struct {
+	ContentTypes []string
+	MaxSize      int64
+	MinSize      int64
+}
+
+// the skip code for an object the filters leave out, empty when it passes
+func (f ObjectFilters) skip(size int64, contentType string) string {
+	if (f.MinSize > 0 && size < f.MinSize) || (f.MaxSize > 0 && size > f.MaxSize) {
+		return "SIZE_FILTERED"
+	}
+
+	if len(f.ContentTypes) > 0 {
+		// the parameters like the charset aren't matched
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		for _, pattern := range f.ContentTypes {
+			if match, _ := path.Match(strings.ToLower(pattern), mediaType); match {
+				return ""
+			}
+		}
+		return "CONTENT_TYPE_FILTERED"
+	}
+
+	return ""
+}
+
+// without include paths every key is included
+func included(includePaths []*pcre.Regexp, key string) bool {
+	if len(includePaths) == 0 {
+		return true
+	}
+
+	for _, includedPathRegexp := range includePaths {
+		if includedPathRegexp.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// the skip code of a copy the exclude or include paths or the filters leave out, empty when it's copied,
+// an exclude match is returned with its pattern
+func (a *Archiver) copySkip(key string, size int64, contentType string) (string, string) {
+	for _, excludedPathRegexp := range a.ExcludePaths.CopyObject {
+		if excludedPathRegexp.MatchString(key) {
+			return "EXCLUDED_PATH", excludedPathRegexp.String()
+		}
+	}
+
+	// an exclude match wins over an include match
+	if !included(a.IncludePaths.CopyObject, key) {
+		return "NOT_INCLUDED_PATH", ""
+	}
+
+	return a.Filters.skip(size, contentType), ""
+}
+
+// copySkip for a listed source object, a listing without the content type stats the object when the filters need it
+func (a *Archiver) listedCopySkip(ctx context.Context, src client.ObjectInfo) (string, error) {
+	skipped, _ := a.copySkip(src.Key, src.Size, src.ContentType)
+	if skipped != "CONTENT_TYPE_FILTERED" || src.ContentType != "" {
+		return skipped, nil
+	}
+
+	srcStat, err := a.SrcClient.StatObject(ctx, a.SrcBucket, src.Key, client.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	skipped, _ = a.copySkip(src.Key, src.Size, srcStat.ContentType)
+	return skipped, nil
+}
@@ -0,0 +1,83 @@
+package archie
+
+import (
+	"go.arsenm.dev/pcre"
+	"testing"
+)
+
+func TestObjectFiltersSkip(t *testing.T) {
+	tests := []struct {
+		name        string
+		filters     ObjectFilters
+		size        int64
+		contentType string
+		want        string
+	}{
+		{"no filters", ObjectFilters{}, 0, "", ""},
+		{"under the min size", ObjectFilters{MinSize: 10}, 9, "", "SIZE_FILTERED"},
+		{"at the min size", ObjectFilters{MinSize: 10}, 10, "", ""},
+		{"over the max size", ObjectFilters{MaxSize: 10}, 11, "", "SIZE_FILTERED"},
+		{"at the max size", ObjectFilters{MaxSize: 10}, 10, "", ""},
+		{"matching content type", ObjectFilters{ContentTypes: []string{"image/*"}}, 1, "image/png", ""},
+		{"content type parameters", ObjectFilters{ContentTypes: []string{"text/plain"}}, 1, "Text/Plain; charset=utf-8", ""},
+		{"pattern case", ObjectFilters{ContentTypes: []string{"IMAGE/*"}}, 1, "image/png", ""},
+		{"other content type", ObjectFilters{ContentTypes: []string{"image/*"}}, 1, "video/mp4", "CONTENT_TYPE_FILTERED"},
+		{"missing content type", ObjectFilters{ContentTypes: []string{"image/*"}}, 1, "", "CONTENT_TYPE_FILTERED"},
+		// the size is checked before the content type
+		{"size and content type", ObjectFilters{ContentTypes: []string{"video/*"}, MaxSize: 10}, 11, "image/png", "SIZE_FILTERED"},
+	}
+
+	for _, test := range tests {
+		if got := test.filters.skip(test.size, test.contentType); got != test.want {
+			t.Errorf("%s: skip(%d, %q) = %q, want %q", test.name, test.size, test.contentType, got, test.want)
+		}
+	}
+}
+
+func TestIncluded(t *testing.T) {
+	includePaths := []*pcre.Regexp{pcre.MustCompile(`^logs/`), pcre.MustCompile(`\.csv$`)}
+
+	tests := []struct {
+		includePaths []*pcre.Regexp
+		key          string
+		want         bool
+	}{
+		{nil, "anything", true},
+		{includePaths, "logs/a.txt", true},
+		{includePaths, "reports/a.csv", true},
+		{includePaths, "reports/a.txt", false},
+	}
+
+	for _, test := range tests {
+		if got := included(test.includePaths, test.key); got != test.want {
+			t.Errorf("included(%q) with %d include paths = %t, want %t", test.key, len(test.includePaths), got, test.want)
+		}
+	}
+}
+
+func TestCopySkip(t *testing.T) {
+	a := &Archiver{Filters: ObjectFilters{MaxSize: 10}}
+	a.ExcludePaths.CopyObject = []*pcre.Regexp{pcre.MustCompile(`\.tmp$`)}
+	a.IncludePaths.CopyObject = []*pcre.Regexp{pcre.MustCompile(`^logs/`)}
+
+	tests := []struct {
+		key     string
+		size    int64
+		want    string
+		pattern string
+	}{
+		{"logs/a.txt", 1, "", ""},
+		{"logs/a.tmp", 1, "EXCLUDED_PATH", `\.tmp$`},
+		// an exclude match wins over an include match
+		{"other/a.tmp", 1, "EXCLUDED_PATH", `\.tmp$`},
+		{"other/a.txt", 1, "NOT_INCLUDED_PATH", ""},
+		{"logs/a.txt", 11, "SIZE_FILTERED", ""},
+	}
+
+	for _, test := range tests {
+		got, pattern := a.copySkip(test.key, test.size, "")
+		if got != test.want || pattern != test.pattern {
+			t.Errorf("copySkip(%q, %d) = %q, %q, want %q, %q", test.key, test.size, got, pattern, test.want, test.pattern)
+		}
+	}
+}
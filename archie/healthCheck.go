@@ -17,7 +17,7 @@ import (
 type HealthCheckStatusListener struct{}
 
 type readinessCheck struct {
-	DestClient    client.Client
+	Destinations  []*Destination
 	SrcClient     client.Client
 	jetStreamConn *nats.Conn
 }
@@ -36,15 +36,16 @@ func (a *Archiver) StartHealthCheckServer(healthCheckPort int, jetStreamConn *na
 	// ready
 	cReadinessCheck := &readinessCheck{
 		SrcClient:     a.SrcClient,
-		DestClient:    a.DestClient,
+		Destinations:  a.Destinations,
 		jetStreamConn: jetStreamConn,
 	}
 	readinessHandler := startHealthCheck("ready", cReadinessCheck)
 
-	srv := &http.Server{Addr: fmt.Sprintf(":%d", healthCheckPort)}
+	mux := http.NewServeMux()
+	mux.Handle("/ready", handlers.NewJSONHandlerFunc(readinessHandler, nil))
+	mux.Handle("/live", handlers.NewJSONHandlerFunc(livenessHandler, nil))
 
-	http.Handle("/ready", handlers.NewJSONHandlerFunc(readinessHandler, nil))
-	http.Handle("/live", handlers.NewJSONHandlerFunc(livenessHandler, nil))
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", healthCheckPort), Handler: mux}
 
 	go func() {
 		defer func() {
@@ -110,8 +111,10 @@ func (c *readinessCheck) Status() (interface{}, error) {
 		return nil, fmt.Errorf("source client health check failed")
 	}
 
-	if c.DestClient.IsOffline() {
-		return nil, fmt.Errorf("destination client health check failed")
+	for _, dest := range c.Destinations {
+		if dest.Client.IsOffline() {
+			return nil, fmt.Errorf("%s destination client health check failed", dest.Name)
+		}
 	}
 
 	if !c.jetStreamConn.IsConnected() {
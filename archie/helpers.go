@@ -3,9 +3,11 @@ package archie
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/minio/minio-go/v7"
 	"github.com/rs/zerolog"
+	"io/fs"
 	"math"
 	"strings"
 )
@@ -93,6 +95,27 @@ func logS3Error(err error, msg string, mLog *zerolog.Logger) (error string, code
 	}
 }
 
+// the missing object errors from each client
+func isObjectNotFound(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		// filesystem error
+		return true
+	}
+
+	switch err.Error() {
+	case "The specified key does not exist.":
+		// minio error
+		return true
+	case "storage: object doesn't exist":
+		// gcs error
+		return true
+	case "The specified blob does not exist.":
+		// azure error
+		return true
+	}
+	return false
+}
+
 func checkContextDone(ctx context.Context) bool {
 	// non-blocking
 	select {
@@ -47,6 +47,16 @@ func sendTermSignal(msg *nats.Msg, mLog *zerolog.Logger) error {
 	return nil
 }
 
+// hold the message for a fixed delay, if the Nak fails jetstream redelivers after its timeout
+func sendDelaySignal(msg *nats.Msg, mLog *zerolog.Logger, delay time.Duration) {
+	mLog.Info().Msgf("Sending JetStream NAck signal and requesting redelivery in %s", delay.String())
+
+	err := msg.NakWithDelay(delay)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to complete JetStream NAck signal")
+	}
+}
+
 // msgs will continue to redeliver via this exponential backoff,
 // if the Nak fails just let jetstream redeliver after its timeout
 func sendNakSignal(msg *nats.Msg, mLog *zerolog.Logger, backoffDurationMultiplier uint64, backoffNumCeiling uint64) {
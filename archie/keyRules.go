@@ -0,0 +1,269 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/compression"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"go.arsenm.dev/pcre"
+	"golang.org/x/exp/slices"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// KeyRule rewrites the source key on its way to the destinations, only one of its rewrites is set
+type KeyRule struct {
+	AddPrefix   string
+	DatePrefix  string
+	Pattern     *pcre.Regexp
+	Replace     string
+	StripPrefix string
+}
+
+var errKeyRuleIrreversible = errors.New("a pattern or stripPrefix key rule can't be undone")
+
+// a concurrent copy of the same source key makes the key index update retry
+const keyIndexAttempts = 3
+
+// the date partition of a destination key is searched for where this date shows up in the rewritten key
+var keyRuleReferenceTime = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+func (r KeyRule) apply(key string, eventTime time.Time) string {
+	switch {
+	case r.StripPrefix != "":
+		return strings.TrimPrefix(key, r.StripPrefix)
+	case r.AddPrefix != "":
+		return r.AddPrefix + key
+	case r.DatePrefix != "":
+		return eventTime.UTC().Format(r.DatePrefix) + key
+	case r.Pattern != nil:
+		return r.Pattern.ReplaceAllString(key, r.Replace)
+	}
+	return key
+}
+
+// rewrite the source key into the destination key, the date partitions use the event time
+func (a *Archiver) destinationKey(key string, eventTime time.Time) string {
+	for _, rule := range a.KeyRules {
+		key = rule.apply(key, eventTime)
+	}
+	return key
+}
+
+// undo the key rule on a destination key, a pattern rule can't be undone and neither can a stripped
+// prefix since the key may never have had it, their source key is recorded with the object
+func (r KeyRule) undo(key string) (string, error) {
+	switch {
+	case r.StripPrefix != "":
+		return "", errKeyRuleIrreversible
+	case r.AddPrefix != "":
+		if !strings.HasPrefix(key, r.AddPrefix) {
+			return "", fmt.Errorf("the key %s isn't under the added prefix %s", key, r.AddPrefix)
+		}
+		return strings.TrimPrefix(key, r.AddPrefix), nil
+	case r.DatePrefix != "":
+		date := regexp.MustCompile("^" + datePattern(keyRuleReferenceTime.Format(r.DatePrefix))).FindString(key)
+		if date == "" {
+			return "", fmt.Errorf("the key %s isn't under a date partition", key)
+		}
+		return strings.TrimPrefix(key, date), nil
+	case r.Pattern != nil:
+		return "", errKeyRuleIrreversible
+	}
+	return key, nil
+}
+
+// the source key recorded with a destination object, otherwise the undone key rules
+func (a *Archiver) recordedSourceKey(ctx context.Context, dest *Destination, destKey string) (string, error) {
+	destStat, err := dest.Client.StatObject(ctx, dest.Bucket, destKey, client.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if recorded := client.MetadataValue(destStat.UserMetadata, client.SourceKeyMetadata); recorded != "" {
+		return url.PathUnescape(recorded)
+	}
+	return a.sourceKey(destKey)
+}
+
+// find the source key of a destination key by undoing the key rules in reverse order, the route's
+// compression suffix is added after the rules so it's removed first when the key's route adds it
+func (a *Archiver) sourceKey(destKey string) (string, error) {
+	for _, codec := range []string{compression.Gzip, compression.Zstd} {
+		suffix := compression.Suffix(codec)
+		if strings.HasSuffix(destKey, suffix) {
+			key, err := a.undoKeyRules(strings.TrimSuffix(destKey, suffix))
+			if err == nil && a.route(key).keySuffix() == suffix {
+				return key, nil
+			}
+		}
+	}
+	return a.undoKeyRules(destKey)
+}
+
+func (a *Archiver) undoKeyRules(key string) (string, error) {
+	var err error
+	for i := len(a.KeyRules) - 1; i >= 0; i-- {
+		key, err = a.KeyRules[i].undo(key)
+		if err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// the destination prefix the objects of a source prefix are listed under, a rule that can't be applied
+// to a partial key lists the whole destination and the source keys are matched instead
+func (a *Archiver) listingPrefix(prefix string) string {
+	destPrefix := prefix
+	for _, rule := range a.KeyRules {
+		if rule.Pattern != nil || (rule.StripPrefix != "" && !strings.HasPrefix(destPrefix, rule.StripPrefix)) {
+			return ""
+		}
+		destPrefix = rule.apply(destPrefix, keyRuleReferenceTime)
+	}
+
+	// every date partition is listed
+	if layout := a.dateLayout(); layout != "" {
+		destPrefix, _, _ = strings.Cut(destPrefix, keyRuleReferenceTime.Format(layout))
+	}
+	return destPrefix
+}
+
+func (a *Archiver) dateLayout() string {
+	for _, rule := range a.KeyRules {
+		if rule.DatePrefix != "" {
+			return rule.DatePrefix
+		}
+	}
+	return ""
+}
+
+// find the destination keys of a source key for a delete, the event doesn't tell the date the object was
+// partitioned under so the date partitioned keys are looked up in the key index, nor if the object was
+// compressed under the route's key suffix
+func (a *Archiver) destinationKeys(ctx context.Context, dest *Destination, key string) ([]string, error) {
+	if a.dateLayout() != "" {
+		keys, _, err := a.indexedKeys(dest, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("no destination key of %s is indexed: %w", key, fs.ErrNotExist)
+		}
+		return keys, nil
+	}
+
+	destKey := a.destinationKey(key, time.Time{})
+	if suffix := a.route(key).keySuffix(); suffix != "" {
+		_, err := dest.Client.StatObject(ctx, dest.Bucket, destKey+suffix, client.GetOptions{})
+		if err == nil {
+			return []string{destKey + suffix}, nil
+		}
+		if !isObjectNotFound(err) {
+			return nil, err
+		}
+	}
+	return []string{destKey}, nil
+}
+
+// the key index keeps the destination keys of each date partitioned source key by destination,
+// the bucket and key are encoded since the key-value keys are limited to a few characters
+func keyIndexKey(dest *Destination, bucket string, key string) string {
+	return "keys." + base64.RawURLEncoding.EncodeToString([]byte(dest.Name)) + "." + base64.RawURLEncoding.EncodeToString([]byte(bucket+"/"+key))
+}
+
+// the indexed destination keys of a source key with the entry's revision, zero when it has none
+func (a *Archiver) indexedKeys(dest *Destination, key string) ([]string, uint64, error) {
+	if a.KeyIndexKV == nil {
+		return nil, 0, errors.New("the key index isn't set up")
+	}
+
+	entry, err := a.KeyIndexKV.Get(keyIndexKey(dest, a.SrcBucket, key))
+	if err == nats.ErrKeyNotFound {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("failed to get the indexed keys of %s: %w", key, err)
+	}
+
+	var keys []string
+	err = json.Unmarshal(entry.Value(), &keys)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal the indexed keys of %s: %w", key, err)
+	}
+	return keys, entry.Revision(), nil
+}
+
+// add the destination key a source key was copied to, the entry is only replaced at the revision
+// it was read at so a concurrent copy under another date partition isn't lost
+func (a *Archiver) indexKey(dest *Destination, key string, destKey string) error {
+	if a.dateLayout() == "" {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < keyIndexAttempts; attempt++ {
+		var keys []string
+		var revision uint64
+		keys, revision, err = a.indexedKeys(dest, key)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(keys, destKey) {
+			return nil
+		}
+
+		var value []byte
+		value, err = json.Marshal(append(keys, destKey))
+		if err != nil {
+			return err
+		}
+
+		if revision == 0 {
+			_, err = a.KeyIndexKV.Create(keyIndexKey(dest, a.SrcBucket, key), value)
+		} else {
+			_, err = a.KeyIndexKV.Update(keyIndexKey(dest, a.SrcBucket, key), value, revision)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to index the destination key %s: %w", destKey, err)
+}
+
+// drop the indexed keys of a source key once its destination objects are removed
+func (a *Archiver) clearIndexedKeys(dest *Destination, key string) error {
+	if a.dateLayout() == "" {
+		return nil
+	}
+
+	err := a.KeyIndexKV.Purge(keyIndexKey(dest, a.SrcBucket, key))
+	if err != nil && err != nats.ErrKeyNotFound {
+		return fmt.Errorf("failed to clear the indexed keys of %s: %w", key, err)
+	}
+	return nil
+}
+
+// the digits and letters of a formatted date match any other date in the same layout
+func datePattern(date string) string {
+	var pattern strings.Builder
+	for _, r := range date {
+		switch {
+		case unicode.IsDigit(r):
+			pattern.WriteString(`\d`)
+		case unicode.IsLetter(r):
+			pattern.WriteString(`\pL`)
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return pattern.String()
+}
@@ -0,0 +1,299 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/compression"
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"go.arsenm.dev/pcre"
+	"golang.org/x/exp/slices"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRuleApply(t *testing.T) {
+	eventTime := time.Date(2023, time.March, 4, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		rule KeyRule
+		key  string
+		want string
+	}{
+		{KeyRule{AddPrefix: "archive/"}, "a/b.txt", "archive/a/b.txt"},
+		{KeyRule{StripPrefix: "tenants/"}, "tenants/a/b.txt", "a/b.txt"},
+		{KeyRule{StripPrefix: "tenants/"}, "other/b.txt", "other/b.txt"},
+		// the date is in UTC
+		{KeyRule{DatePrefix: "2006/01/02/"}, "a/b.txt", "2023/03/05/a/b.txt"},
+		{KeyRule{Pattern: pcre.MustCompile(`^([^/]+)/(.*)$`), Replace: "$2/$1"}, "a/b.txt", "b.txt/a"},
+		{KeyRule{}, "a/b.txt", "a/b.txt"},
+	}
+
+	for _, test := range tests {
+		if got := test.rule.apply(test.key, eventTime); got != test.want {
+			t.Errorf("%+v apply(%q) = %q, want %q", test.rule, test.key, got, test.want)
+		}
+	}
+}
+
+func TestKeyRuleUndo(t *testing.T) {
+	tests := []struct {
+		rule KeyRule
+		key  string
+		want string
+		err  error
+	}{
+		{KeyRule{AddPrefix: "archive/"}, "archive/a/b.txt", "a/b.txt", nil},
+		{KeyRule{AddPrefix: "archive/"}, "a/b.txt", "", errors.New("not under the prefix")},
+		{KeyRule{DatePrefix: "2006/01/02/"}, "2023/03/05/a/b.txt", "a/b.txt", nil},
+		{KeyRule{DatePrefix: "2006/01/02/"}, "a/b.txt", "", errors.New("not under a date")},
+		// a key that never had the stripped prefix can't be told apart from one that had it
+		{KeyRule{StripPrefix: "tenants/"}, "a/b.txt", "", errKeyRuleIrreversible},
+		{KeyRule{Pattern: pcre.MustCompile(`^(.*)$`), Replace: "$1"}, "a/b.txt", "", errKeyRuleIrreversible},
+	}
+
+	for _, test := range tests {
+		got, err := test.rule.undo(test.key)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("%+v undo(%q): %v", test.rule, test.key, err)
+		case test.err != nil && err == nil:
+			t.Errorf("%+v undo(%q) = %q, want an error", test.rule, test.key, got)
+		case errors.Is(test.err, errKeyRuleIrreversible) && !errors.Is(err, errKeyRuleIrreversible):
+			t.Errorf("%+v undo(%q) = %v, want %v", test.rule, test.key, err, errKeyRuleIrreversible)
+		case got != test.want:
+			t.Errorf("%+v undo(%q) = %q, want %q", test.rule, test.key, got, test.want)
+		}
+	}
+}
+
+func TestSourceKey(t *testing.T) {
+	a := &Archiver{
+		DefaultRoute: &Route{Compression: compression.Zstd, CompressionSuffix: true},
+		KeyRules:     []KeyRule{{DatePrefix: "2006/01/02/"}, {AddPrefix: "archive/"}},
+	}
+
+	tests := []struct {
+		destKey string
+		want    string
+	}{
+		{"archive/2023/03/05/a/b.txt.zst", "a/b.txt"},
+		// an object that wasn't compressed keeps its key
+		{"archive/2023/03/05/a/b.txt", "a/b.txt"},
+	}
+
+	for _, test := range tests {
+		got, err := a.sourceKey(test.destKey)
+		if err != nil {
+			t.Errorf("sourceKey(%q): %v", test.destKey, err)
+		} else if got != test.want {
+			t.Errorf("sourceKey(%q) = %q, want %q", test.destKey, got, test.want)
+		}
+	}
+
+	if _, err := a.sourceKey("2023/03/05/a/b.txt"); err == nil {
+		t.Error("a key without the added prefix was undone")
+	}
+}
+
+func TestListingPrefix(t *testing.T) {
+	tests := []struct {
+		rules  []KeyRule
+		prefix string
+		want   string
+	}{
+		{nil, "logs/", "logs/"},
+		{[]KeyRule{{AddPrefix: "archive/"}}, "logs/", "archive/logs/"},
+		// every date partition is listed
+		{[]KeyRule{{DatePrefix: "2006/01/02/"}, {AddPrefix: "archive/"}}, "logs/", "archive/"},
+		{[]KeyRule{{StripPrefix: "tenants/"}}, "tenants/a/", "a/"},
+		// a prefix the rule may or may not strip lists the whole destination
+		{[]KeyRule{{StripPrefix: "tenants/"}}, "ten", ""},
+		{[]KeyRule{{Pattern: pcre.MustCompile(`^(.*)$`), Replace: "$1"}}, "logs/", ""},
+	}
+
+	for _, test := range tests {
+		a := &Archiver{KeyRules: test.rules}
+		if got := a.listingPrefix(test.prefix); got != test.want {
+			t.Errorf("%+v listingPrefix(%q) = %q, want %q", test.rules, test.prefix, got, test.want)
+		}
+	}
+}
+
+// a filesystem destination in a temporary directory
+func testDestination(t *testing.T) *Destination {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "bucket"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	fsClient := &client.Filesystem{}
+	cancel := fsClient.New(context.Background(), "test", "bucket", root, client.Credentials{}, false, client.Params{}, zerolog.Disabled)
+	t.Cleanup(cancel)
+
+	return &Destination{Bucket: "bucket", Client: fsClient, Name: "test"}
+}
+
+func putTestObject(t *testing.T, dest *Destination, key string, userMetadata map[string]string) {
+	t.Helper()
+
+	_, err := dest.Client.PutObject(context.Background(), dest.Bucket, key, strings.NewReader(key), int64(len(key)), client.PutOptions{UserMetadata: userMetadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// an in-memory key-value bucket with the revisions of a jetstream one
+type testKeyValue struct {
+	nats.KeyValue
+	entries map[string]*testKeyValueEntry
+	// the revision of the last write across the bucket
+	revision uint64
+}
+
+type testKeyValueEntry struct {
+	nats.KeyValueEntry
+	revision uint64
+	value    []byte
+}
+
+func (e *testKeyValueEntry) Revision() uint64 { return e.revision }
+func (e *testKeyValueEntry) Value() []byte    { return e.value }
+
+func newTestKeyValue() *testKeyValue {
+	return &testKeyValue{entries: map[string]*testKeyValueEntry{}}
+}
+
+func (kv *testKeyValue) Get(key string) (nats.KeyValueEntry, error) {
+	entry, ok := kv.entries[key]
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+func (kv *testKeyValue) Create(key string, value []byte) (uint64, error) {
+	if _, ok := kv.entries[key]; ok {
+		return 0, nats.ErrKeyExists
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *testKeyValue) Update(key string, value []byte, last uint64) (uint64, error) {
+	entry, ok := kv.entries[key]
+	if !ok || entry.revision != last {
+		return 0, errors.New("wrong last sequence")
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *testKeyValue) Purge(key string, _ ...nats.DeleteOpt) error {
+	delete(kv.entries, key)
+	return nil
+}
+
+func (kv *testKeyValue) put(key string, value []byte) uint64 {
+	kv.revision++
+	kv.entries[key] = &testKeyValueEntry{revision: kv.revision, value: value}
+	return kv.revision
+}
+
+func TestDestinationKeys(t *testing.T) {
+	dest := testDestination(t)
+	other := testDestination(t)
+	other.Name = "other"
+
+	a := &Archiver{
+		Destinations: []*Destination{dest, other},
+		KeyIndexKV:   newTestKeyValue(),
+		KeyRules:     []KeyRule{{DatePrefix: "2006/01/02/"}, {AddPrefix: "archive/"}},
+		SrcBucket:    "source",
+	}
+
+	// a copy on another day adds a partition, a redelivery doesn't add it again
+	for _, destKey := range []string{"archive/2023/03/04/a/b.txt", "archive/2023/03/05/a/b.txt", "archive/2023/03/05/a/b.txt"} {
+		if err := a.indexKey(dest, "a/b.txt", destKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.indexKey(other, "a/b.txt", "archive/2023/03/06/a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := a.destinationKeys(context.Background(), dest, "a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"archive/2023/03/04/a/b.txt", "archive/2023/03/05/a/b.txt"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("destinationKeys = %v, want %v", keys, want)
+	}
+
+	// a removed object's keys are dropped from its own destination only
+	if err := a.clearIndexedKeys(dest, "a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.destinationKeys(context.Background(), dest, "a/b.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("destinationKeys of a cleared key = %v, want fs.ErrNotExist", err)
+	}
+	if keys, err := a.destinationKeys(context.Background(), other, "a/b.txt"); err != nil || len(keys) != 1 {
+		t.Errorf("destinationKeys of the other destination = %v, %v, want its key", keys, err)
+	}
+}
+
+func TestDestinationKeysWithoutDate(t *testing.T) {
+	dest := testDestination(t)
+	a := &Archiver{
+		DefaultRoute: &Route{Compression: compression.Zstd, CompressionSuffix: true},
+		Destinations: []*Destination{dest},
+		KeyRules:     []KeyRule{{AddPrefix: "archive/"}},
+	}
+
+	// the key is computed, the suffix is only used when the compressed object exists
+	putTestObject(t, dest, "archive/a.txt.zst", nil)
+
+	for key, want := range map[string]string{"a.txt": "archive/a.txt.zst", "b.txt": "archive/b.txt"} {
+		keys, err := a.destinationKeys(context.Background(), dest, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(keys, []string{want}) {
+			t.Errorf("destinationKeys(%q) = %v, want %s", key, keys, want)
+		}
+	}
+}
+
+func TestRecordedSourceKey(t *testing.T) {
+	dest := testDestination(t)
+	a := &Archiver{
+		Destinations: []*Destination{dest},
+		KeyRules:     []KeyRule{{StripPrefix: "tenants/"}},
+	}
+
+	// the stripped and the unmatched key look the same, only the recorded key tells them apart
+	putTestObject(t, dest, "a/b.txt", map[string]string{client.SourceKeyMetadata: url.PathEscape("tenants/a/b.txt")})
+	putTestObject(t, dest, "c/d.txt", map[string]string{client.SourceKeyMetadata: url.PathEscape("c/d.txt")})
+	putTestObject(t, dest, "e.txt", nil)
+
+	for destKey, want := range map[string]string{"a/b.txt": "tenants/a/b.txt", "c/d.txt": "c/d.txt"} {
+		got, err := a.recordedSourceKey(context.Background(), dest, destKey)
+		if err != nil {
+			t.Errorf("recordedSourceKey(%q): %v", destKey, err)
+		} else if got != want {
+			t.Errorf("recordedSourceKey(%q) = %q, want %q", destKey, got, want)
+		}
+	}
+
+	if _, err := a.recordedSourceKey(context.Background(), dest, "e.txt"); !errors.Is(err, errKeyRuleIrreversible) {
+		t.Errorf("recordedSourceKey without a recorded key = %v, want %v", err, errKeyRuleIrreversible)
+	}
+}
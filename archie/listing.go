@@ -0,0 +1,177 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/encryption"
+	"context"
+	"strconv"
+)
+
+// object differences between the source and a destination
+const (
+	DifferenceETag    = "etag"
+	DifferenceExtra   = "extra"
+	DifferenceMissing = "missing"
+	DifferenceSize    = "size"
+)
+
+// listCursor steps through a listing in lexical key order so it can be merged with the source listing
+type listCursor struct {
+	objectCh <-chan client.ObjectInfo
+	current  *client.ObjectInfo
+	started  bool
+}
+
+func newListCursor(objectCh <-chan client.ObjectInfo) *listCursor {
+	return &listCursor{objectCh: objectCh}
+}
+
+func (c *listCursor) next() error {
+	c.started = true
+
+	info, ok := <-c.objectCh
+	if !ok {
+		c.current = nil
+		return nil
+	}
+	if info.Err != nil {
+		c.current = nil
+		return info.Err
+	}
+
+	c.current = &info
+	return nil
+}
+
+// the marker to resume the listing from, the keys before the current one were already passed
+func (c *listCursor) marker() string {
+	if c == nil || c.current == nil {
+		return ""
+	}
+	return c.current.Marker
+}
+
+// seek to the key and return its object, nil when the listing doesn't have it,
+// the keys skipped on the way are passed to extra
+func (c *listCursor) seek(key string, extra func(info client.ObjectInfo)) (*client.ObjectInfo, error) {
+	if !c.started {
+		err := c.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for c.current != nil && c.current.Key < key {
+		if extra != nil {
+			extra(*c.current)
+		}
+		err := c.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.current != nil && c.current.Key == key {
+		found := c.current
+		err := c.next()
+		return found, err
+	}
+
+	return nil, nil
+}
+
+// drain the rest of the listing, each key is passed to extra
+func (c *listCursor) drain(extra func(info client.ObjectInfo)) error {
+	if !c.started {
+		err := c.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	for c.current != nil {
+		if extra != nil {
+			extra(*c.current)
+		}
+		err := c.next()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find the destination object of a source object, the rewritten keys don't list in the
+// source's order so with key rules or compression it's looked up by its key instead of seeking the listing
+func (a *Archiver) destinationObject(ctx context.Context, dest *Destination, cursor *listCursor, src client.ObjectInfo, extra func(info client.ObjectInfo)) (*client.ObjectInfo, error) {
+	if cursor != nil {
+		return cursor.seek(src.Key, extra)
+	}
+
+	// a compressed object has the suffix, one that was already compressed doesn't
+	destKey := a.destinationKey(src.Key, src.LastModified)
+	destKeys := []string{destKey}
+	if suffix := a.route(src.Key).keySuffix(); suffix != "" {
+		destKeys = []string{destKey + suffix, destKey}
+	}
+
+	for _, destKey := range destKeys {
+		destInfo, err := dest.Client.StatObject(ctx, dest.Bucket, destKey, client.GetOptions{})
+		if err == nil {
+			return destInfo, nil
+		}
+		if !isObjectNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// the destination listings to merge with the source listing, none when the keys are rewritten
+// or the objects are compressed, the markers resume the listings by destination name
+func (a *Archiver) destinationCursors(ctx context.Context, prefix string, opts client.ListOptions, markers map[string]string) []*listCursor {
+	destCursors := make([]*listCursor, len(a.Destinations))
+	if len(a.KeyRules) > 0 || a.compressedRoutes() {
+		return destCursors
+	}
+
+	for i, dest := range a.Destinations {
+		destOpts := opts
+		destOpts.Marker = markers[dest.Name]
+		destCursors[i] = newListCursor(dest.Client.ListObjects(ctx, dest.Bucket, prefix, destOpts))
+	}
+	return destCursors
+}
+
+// compare a source object with its destination object, empty when they match,
+// the ETag is only compared when both sides have one
+func (a *Archiver) objectDifference(src client.ObjectInfo, dest *client.ObjectInfo) string {
+	if dest == nil {
+		return DifferenceMissing
+	}
+	if src.Size != a.sourceSize(src, dest) {
+		return DifferenceSize
+	}
+	if src.ETag != "" && dest.ETag != "" && src.ETag != dest.ETag {
+		return DifferenceETag
+	}
+	return ""
+}
+
+// an identical object needs a matching ETag, unlike objectDifference a missing ETag doesn't count
+func (a *Archiver) identicalObject(src *client.ObjectInfo, dest *client.ObjectInfo) bool {
+	return src.Size == a.sourceSize(*src, dest) && src.ETag != "" && src.ETag == dest.ETag
+}
+
+// the size of the source object the destination object was written from, an encrypted object records it
+// but a listing without its metadata falls back to the encrypted size of the source
+func (a *Archiver) sourceSize(src client.ObjectInfo, dest *client.ObjectInfo) int64 {
+	originalSize, err := strconv.ParseInt(client.MetadataValue(dest.UserMetadata, client.OriginalSizeMetadata), 10, 64)
+	if err == nil {
+		return originalSize
+	}
+	if a.Encryption != nil && dest.Size == encryption.EncryptedSize(src.Size) {
+		return src.Size
+	}
+	return dest.Size
+}
@@ -0,0 +1,305 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/encryption"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the manifest operations
+const (
+	ManifestCopy   = "copy"
+	ManifestDelete = "delete"
+)
+
+// the manifest objects are grouped by the day of their entries
+const manifestDayLayout = "2006/01/02/"
+
+// a failing destination keeps the entries in memory up to this many, then the oldest are dropped
+const manifestMaxBacklog = 1000000
+
+// ManifestEntry is a copy or delete archie applied to the destinations, the time is the event time
+type ManifestEntry struct {
+	Destinations []string  `json:"destinations"`
+	ETag         string    `json:"etag,omitempty"`
+	Key          string    `json:"key"`
+	Op           string    `json:"op"`
+	Size         int64     `json:"size,omitempty"`
+	Time         time.Time `json:"time"`
+	VersionID    string    `json:"versionId,omitempty"`
+}
+
+// Manifest buffers the entries and appends them to its destination as new jsonl objects under
+// the prefix and day of the entries, each replica's objects are named by its writer
+type Manifest struct {
+	Destination   *Destination
+	FlushInterval time.Duration
+	MaxEntries    int
+	Prefix        string
+	Writer        string
+
+	entries []ManifestEntry
+	lock    sync.Mutex
+}
+
+// record an applied copy or delete, a full buffer is flushed right away
+func (a *Archiver) recordManifest(entry ManifestEntry) {
+	if a.Manifest == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	a.Manifest.lock.Lock()
+	a.Manifest.entries = append(a.Manifest.entries, entry)
+	full := len(a.Manifest.entries) == a.Manifest.MaxEntries
+	a.Manifest.lock.Unlock()
+
+	if full {
+		go func() {
+			_ = a.FlushManifest(context.Background())
+		}()
+	}
+}
+
+// StartManifestWriter flushes the manifest every interval until the context is canceled,
+// the last entries are flushed by FlushManifest on shutdown
+func (a *Archiver) StartManifestWriter(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(a.Manifest.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = a.FlushManifest(ctx)
+			}
+		}
+	}()
+}
+
+// FlushManifest writes the buffered entries, the entries of a failed write are kept for the next flush
+func (a *Archiver) FlushManifest(ctx context.Context) error {
+	if a.Manifest == nil {
+		return nil
+	}
+
+	m := a.Manifest
+	m.lock.Lock()
+	entries := m.entries
+	m.entries = nil
+	m.lock.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	days := map[string][]ManifestEntry{}
+	for _, entry := range entries {
+		day := entry.Time.Format(manifestDayLayout)
+		days[day] = append(days[day], entry)
+	}
+
+	var failed []ManifestEntry
+	var flushErr error
+	for day, dayEntries := range days {
+		key := fmt.Sprintf("%s%s%d-%s.jsonl", m.Prefix, day, time.Now().UnixNano(), m.Writer)
+
+		err := a.putManifest(ctx, key, dayEntries)
+		if err != nil {
+			log.Error().Err(err).Str("manifestKey", key).Int("entries", len(dayEntries)).Msg("Failed to write the manifest")
+			a.countManifestFlushesMetric("failed")
+			failed = append(failed, dayEntries...)
+			flushErr = err
+			continue
+		}
+
+		log.Debug().Str("manifestKey", key).Int("entries", len(dayEntries)).Msg("Manifest written")
+		a.countManifestFlushesMetric("written")
+	}
+
+	if len(failed) > 0 {
+		m.lock.Lock()
+		m.entries = append(failed, m.entries...)
+		if dropped := len(m.entries) - manifestMaxBacklog; dropped > 0 {
+			log.Error().Int("dropped", dropped).Msg("Manifest backlog is full, the oldest entries are dropped")
+			m.entries = m.entries[dropped:]
+		}
+		m.lock.Unlock()
+	}
+
+	return flushErr
+}
+
+func (a *Archiver) putManifest(ctx context.Context, key string, entries []ManifestEntry) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, entry := range entries {
+		err := encoder.Encode(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	var reader io.Reader = &buffer
+	objectSize := int64(buffer.Len())
+	opts := client.PutOptions{ContentType: "application/x-ndjson"}
+
+	// the manifest lists the keys so it's encrypted like the objects
+	if a.Encryption != nil {
+		var err error
+		reader, objectSize, opts.UserMetadata, err = a.Encryption.Encrypt(reader, objectSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	dest := a.Manifest.Destination
+	_, err := dest.Client.PutObject(ctx, dest.Bucket, key, reader, objectSize, opts)
+	return err
+}
+
+func (a *Archiver) isManifest(key string) bool {
+	return a.Manifest != nil && strings.HasPrefix(key, a.Manifest.Prefix)
+}
+
+// SnapshotOptions select the objects of a snapshot
+type SnapshotOptions struct {
+	// only the entries applied to this destination, every destination when empty
+	Destination string
+	Prefix      string
+	Time        time.Time
+}
+
+// Snapshot reconstructs the source objects as of a time from the manifest, the days up to the time are read
+// in order and the entries of each day are applied in time order, a delete of another version keeps the object
+func (a *Archiver) Snapshot(ctx context.Context, opts SnapshotOptions) ([]ManifestEntry, error) {
+	// canceling the listing stops its goroutine on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	lastDay := opts.Time.UTC().Format(manifestDayLayout)
+
+	objects := map[string]ManifestEntry{}
+	var currentDay string
+	var dayEntries []ManifestEntry
+
+	dest := a.Manifest.Destination
+	for info := range dest.Client.ListObjects(listCtx, dest.Bucket, a.Manifest.Prefix, client.ListOptions{}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("failed to list the manifest: %w", info.Err)
+		}
+
+		day := strings.TrimPrefix(info.Key, a.Manifest.Prefix)
+		if len(day) < len(lastDay) {
+			continue
+		}
+		day = day[:len(lastDay)]
+
+		// the days list in order so the listing stops after the last one
+		if day > lastDay {
+			break
+		}
+		if day != currentDay {
+			applyManifestEntries(objects, dayEntries)
+			currentDay, dayEntries = day, nil
+		}
+
+		manifestEntries, err := a.readManifest(ctx, info.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the manifest %s: %w", info.Key, err)
+		}
+
+		for _, entry := range manifestEntries {
+			if entry.Time.After(opts.Time) || !strings.HasPrefix(entry.Key, opts.Prefix) {
+				continue
+			}
+			if opts.Destination != "" && !slices.Contains(entry.Destinations, opts.Destination) {
+				continue
+			}
+			dayEntries = append(dayEntries, entry)
+		}
+	}
+	applyManifestEntries(objects, dayEntries)
+
+	snapshot := make([]ManifestEntry, 0, len(objects))
+	for _, object := range objects {
+		snapshot = append(snapshot, object)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Key < snapshot[j].Key
+	})
+
+	return snapshot, nil
+}
+
+func applyManifestEntries(objects map[string]ManifestEntry, entries []ManifestEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	for _, entry := range entries {
+		current, exists := objects[entry.Key]
+		switch {
+		case entry.Op == ManifestCopy:
+			objects[entry.Key] = entry
+		case exists && (entry.VersionID == "" || current.VersionID == "" || entry.VersionID == current.VersionID):
+			delete(objects, entry.Key)
+		}
+	}
+}
+
+func (a *Archiver) readManifest(ctx context.Context, key string) ([]ManifestEntry, error) {
+	dest := a.Manifest.Destination
+	object, err := dest.Client.GetObject(ctx, dest.Bucket, key, client.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	reader := object.GetReader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	stat, err := object.Stat(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if encryption.IsEncrypted(stat.UserMetadata) {
+		if a.Encryption == nil {
+			return nil, fmt.Errorf("the manifest is encrypted but no encryption key file is configured")
+		}
+		reader, err = a.Encryption.Decrypt(reader, stat.UserMetadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry ManifestEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
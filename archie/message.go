@@ -11,21 +11,22 @@ import (
 	"strings"
 )
 
-func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
+// process a message, the ack sent is returned, Nak or Delayed when it's left for a redelivery and Term when it was terminated
+func (a *Archiver) message(ctx context.Context, msg *nats.Msg) AckType {
 	aLog := log.With().Logger()
 
 	metadata, err := msg.Metadata()
 	if err != nil {
 		log.Error().Msg("Failed to retrieve metadata from the event message")
 		sendNakSignal(msg, &aLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
-		return
+		return Nak
 	}
 
 	msgMetadata, err := json.Marshal(metadata)
 	if err != nil {
 		log.Error().Msg("Failed to marshal metadata to json")
 		sendNakSignal(msg, &aLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
-		return
+		return Nak
 	}
 
 	event := evt.Minio{}
@@ -38,7 +39,7 @@ func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
 			log.Error().RawJSON("metadata", msgMetadata).Str("payload", string(msg.Data)).Err(err).Msg(errMsg)
 		}
 		sendNakSignal(msg, &aLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
-		return
+		return Nak
 	}
 
 	log.Debug().RawJSON("metadata", msgMetadata).RawJSON("payload", msg.Data).Msg("Message received - Raw")
@@ -54,41 +55,44 @@ func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
 	err = a.validateEventName(event)
 	if err != nil {
 		mLog.Error().Err(err).Msg("Failed to validate the event name")
-		err = sendTermSignal(msg, &mLog)
+		err = a.sendDeadLetterThenTerm(msg, &mLog, err.Error(), "INT_TERM_INVALID_EVENT_NAME")
 		if err != nil {
 			// logging already happened
-			return
+			return Nak
 		}
 		a.cleanupAndCountMessagesProcessedMetric("terminated", "Event name not in list of valid events", "INT_TERM_INVALID_EVENT_NAME", event.EventName, eventType)
-		return
+		return Term
 	}
 
 	err = a.validateEventBucket(eventBucket)
 	if err != nil {
 		mLog.Error().Err(err).Msg("Failed to validate the event bucket")
-		err = sendTermSignal(msg, &mLog)
+		err = a.sendDeadLetterThenTerm(msg, &mLog, err.Error(), "INT_TERM_INVALID_EVENT_BUCKET")
 		if err != nil {
 			// logging already happened
-			return
+			return Nak
 		}
 		a.cleanupAndCountMessagesProcessedMetric("terminated", "Event bucket and config bucket do not match", "INT_TERM_INVALID_EVENT_BUCKET", event.EventName, eventType)
-		return
+		return Term
 	}
 
+	sent := Ack
 	for _, eventRecord := range event.Records {
 		// object key in the event record needs url decode
 		eventObjKey, err := url.QueryUnescape(eventRecord.S3.Object.Key)
 		if err != nil {
 			mLog.Error().Err(err).Msg("Failed to unescape source object key from event")
 			sendNakSignal(msg, &mLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
+			sent = Nak
 			continue
 		}
 
 		mLog.Info().
 			Str("eventBucket", eventRecord.S3.Bucket.Name).
 			Str("srcBucket", a.SrcBucket).
-			Str("destBucket", a.DestBucket).
+			Strs("destinations", DestinationNames(a.Destinations)).
 			Str("etag", eventRecord.S3.Object.ETag).
+			Str("versionId", eventRecord.S3.Object.VersionID).
 			Int64("bytes", eventRecord.S3.Object.Size).
 			Uint64("numDelivered", metadata.NumDelivered).
 			Str("sourceHost", eventRecord.Source.Host).
@@ -110,7 +114,7 @@ func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
 				s3ErrMsg, s3ErrCode = logS3Error(err, execContext, &mLog)
 			}
 		default:
-			mLog.Error().Msgf("Unable to process the %s event type", event.EventName, eventType)
+			mLog.Error().Msgf("Unable to process the %s event type", eventType)
 			ack = Nak
 		}
 
@@ -120,6 +124,7 @@ func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
 			err = sendAckSignal(msg, &mLog)
 			if err != nil {
 				// logging already happened
+				sent = Nak
 				continue
 			}
 			a.cleanupAndCountMessagesProcessedMetric("success", "", "", event.EventName, eventType)
@@ -127,40 +132,65 @@ func (a *Archiver) message(ctx context.Context, msg *nats.Msg) {
 			err = sendAckSignal(msg, &mLog)
 			if err != nil {
 				// logging already happened
+				sent = Nak
 				continue
 			}
 			a.cleanupAndCountMessagesProcessedMetric("skipped", "", execContext, event.EventName, eventType)
 		case Nak:
 			sendNakSignal(msg, &mLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
+			sent = Nak
 			a.cleanupAndCountMessagesProcessedMetric("failed", s3ErrMsg, s3ErrCode, event.EventName, eventType)
 		case NakThenTerm:
 			maxDelivered := a.MaxRetries - 1
 			if metadata.NumDelivered > maxDelivered {
 				mLog.Error().Uint64("numDelivered", metadata.NumDelivered).Msg("Reached max delivered")
-				termErr := sendTermSignal(msg, &mLog)
+				termErr := a.sendDeadLetterThenTerm(msg, &mLog, fmt.Sprintf("Reached max delivered: %s", s3ErrMsg), s3ErrCode)
 				if termErr != nil {
 					// logging already happened
+					sent = Nak
 					continue
 				}
+				sent = terminated(sent)
+				a.clearProgress(mLog, metadata)
 				a.cleanupAndCountMessagesProcessedMetric("terminated", fmt.Sprintf("Term %s", s3ErrMsg), "INT_TERM5", event.EventName, eventType)
 			} else {
 				sendNakSignal(msg, &mLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
+				sent = Nak
 				a.cleanupAndCountMessagesProcessedMetric("failed", s3ErrMsg, s3ErrCode, event.EventName, eventType)
 			}
 		case Term:
-			termErr := sendTermSignal(msg, &mLog)
+			termErr := a.sendDeadLetterThenTerm(msg, &mLog, s3ErrMsg, s3ErrCode)
 			if termErr != nil {
 				// logging already happened
+				sent = Nak
 				continue
 			}
-			a.cleanupAndCountMessagesProcessedMetric("terminated", termErr.Error(), "INT_TERM", event.EventName, eventType)
+			sent = terminated(sent)
+			a.clearProgress(mLog, metadata)
+			a.cleanupAndCountMessagesProcessedMetric("terminated", fmt.Sprintf("Term %s", s3ErrMsg), "INT_TERM", event.EventName, eventType)
+		case Delayed:
+			if sent != Nak {
+				sent = Delayed
+			}
+			a.cleanupAndCountMessagesProcessedMetric("delayed", "", execContext, event.EventName, eventType)
 		case None:
 			continue
 		default:
 			mLog.Error().Msgf("Unable to process the %s ack type", ack)
 			sendNakSignal(msg, &mLog, a.BackoffDurationMultiplier, a.BackoffNumCeiling)
+			sent = Nak
 			a.cleanupAndCountMessagesProcessedMetric("failed", fmt.Sprintf("Unable to process %s ack type", ack), s3ErrCode, event.EventName, eventType)
 			continue
 		}
 	}
+
+	return sent
+}
+
+// a terminated message only counts when no other record of it was left for a redelivery
+func terminated(sent AckType) AckType {
+	if sent == Ack {
+		return Term
+	}
+	return sent
 }
@@ -9,7 +9,7 @@ import (
 
 // validate event name is allowed
 func (a *Archiver) validateEventName(event event.Minio) error {
-	validEvents := []string{"s3:ObjectCreated:Put", "s3:ObjectCreated:CompleteMultipartUpload", "s3:ObjectRemoved:Delete"}
+	validEvents := []string{"s3:ObjectCreated:Put", "s3:ObjectCreated:CompleteMultipartUpload", "s3:ObjectRemoved:Delete", "s3:ObjectRemoved:DeleteMarkerCreated"}
 	if !slices.Contains(validEvents, event.EventName) {
 		return fmt.Errorf("event name not in list of valid events: [%s], terminating retries", strings.Join(validEvents, ", "))
 	}
@@ -0,0 +1,82 @@
+package archie
+
+import (
+	"archie/client"
+	"path"
+	"strings"
+)
+
+// MetadataOptions select the source headers, user metadata and tags that are kept on the destinations,
+// the allow and deny lists are case-insensitive glob patterns matched against their names
+type MetadataOptions struct {
+	Allow    []string
+	Deny     []string
+	Disabled bool
+	Tags     bool
+}
+
+// a denied name is never propagated, with an allow list only the matching names are
+func (o MetadataOptions) allowed(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, pattern := range o.Deny {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return false
+		}
+	}
+
+	if len(o.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range o.Allow {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (o MetadataOptions) filter(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	filtered := map[string]string{}
+	for name, value := range values {
+		// archie's own metadata is set by the clients
+		if !client.IsInternalMetadata(name) && o.allowed(name) {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
+
+// copy the source object's headers, user metadata and tags to the put options,
+// the content type is always kept
+func (a *Archiver) preserveMetadata(opts *client.PutOptions, srcStat *client.ObjectInfo) {
+	if a.Metadata.Disabled {
+		return
+	}
+
+	headers := []struct {
+		name   string
+		value  string
+		target *string
+	}{
+		{"Cache-Control", srcStat.CacheControl, &opts.CacheControl},
+		{"Content-Disposition", srcStat.ContentDisposition, &opts.ContentDisposition},
+		{"Content-Encoding", srcStat.ContentEncoding, &opts.ContentEncoding},
+		{"Content-Language", srcStat.ContentLanguage, &opts.ContentLanguage},
+	}
+	for _, header := range headers {
+		if header.value != "" && a.Metadata.allowed(header.name) {
+			*header.target = header.value
+		}
+	}
+
+	opts.UserMetadata = a.Metadata.filter(srcStat.UserMetadata)
+
+	if a.Metadata.Tags {
+		opts.Tags = a.Metadata.filter(srcStat.Tags)
+	}
+}
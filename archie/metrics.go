@@ -22,24 +22,33 @@ var (
 	)
 
 	// transfer
-	messagesTransferDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Subsystem: subSystem,
-		Name:      "messages_transfer_duration",
-		Help:      "a histogram of file transfer duration in seconds",
-		Buckets:   []float64{3, 5, 10, 30, 60, 120, 240, 300, 600, 900, 1800, 3600},
-	})
-	messagesTransferRateMetric = promauto.NewHistogram(prometheus.HistogramOpts{
-		Subsystem: subSystem,
-		Name:      "messages_transfer_rate",
-		Help:      "a histogram of file transfer speed in kbytes/second",
-		Buckets:   []float64{500, 1_000, 5_000, 10_000, 12_000, 15_000, 20_000, 25_000, 30_000, 50_000, 70_000},
-	})
-	messagesTransferSizeMetric = promauto.NewHistogram(prometheus.HistogramOpts{
-		Subsystem: subSystem,
-		Name:      "messages_transfer_size",
-		Help:      "a histogram of file transfer size in kbytes",
-		Buckets:   []float64{1_000, 10_000, 50_000, 100_000, 500_000, 1_000_000, 5_000_000, 10_000_000, 20_000_000, 50_000_000},
-	})
+	messagesTransferDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      "messages_transfer_duration",
+			Help:      "a histogram of file transfer duration in seconds",
+			Buckets:   []float64{3, 5, 10, 30, 60, 120, 240, 300, 600, 900, 1800, 3600},
+		},
+		[]string{"destination", "storage_class"},
+	)
+	messagesTransferRateMetric = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      "messages_transfer_rate",
+			Help:      "a histogram of file transfer speed in kbytes/second",
+			Buckets:   []float64{500, 1_000, 5_000, 10_000, 12_000, 15_000, 20_000, 25_000, 30_000, 50_000, 70_000},
+		},
+		[]string{"destination", "storage_class"},
+	)
+	messagesTransferSizeMetric = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      "messages_transfer_size",
+			Help:      "a histogram of file transfer size in kbytes",
+			Buckets:   []float64{1_000, 10_000, 50_000, 100_000, 500_000, 1_000_000, 5_000_000, 10_000_000, 20_000_000, 50_000_000},
+		},
+		[]string{"destination", "storage_class"},
+	)
 	messagesTransferNumDeliveredMetric = promauto.NewHistogram(prometheus.HistogramOpts{
 		Subsystem: subSystem,
 		Name:      "messages_transfer_delivered_count",
@@ -54,12 +63,15 @@ var (
 	})
 
 	// delete
-	messagesDeleteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Subsystem: subSystem,
-		Name:      "messages_delete_duration",
-		Help:      "a histogram of file delete duration in seconds",
-		Buckets:   []float64{1, 2, 3, 5, 10, 30, 60},
-	})
+	messagesDeleteDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      "messages_delete_duration",
+			Help:      "a histogram of file delete duration in seconds",
+			Buckets:   []float64{1, 2, 3, 5, 10, 30, 60},
+		},
+		[]string{"destination"},
+	)
 	messagesDeleteNumDeliveredMetric = promauto.NewHistogram(prometheus.HistogramOpts{
 		Subsystem: subSystem,
 		Name:      "messages_delete_delivered_count",
@@ -72,21 +84,85 @@ var (
 		Help:      "a histogram of the duration of time a delete jetstream message spent waiting and retrying in the queue in seconds",
 		Buckets:   []float64{10, 30, 60, 120, 240, 300, 600, 900, 1800, 3600, 7200, 21_600, 43_200},
 	})
+
+	// checksum
+	checksumVerificationsCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subSystem,
+			Name:      "checksum_verifications_count",
+			Help:      "count of transferred object checksum verifications by result",
+		},
+		[]string{"destination", "result"},
+	)
+
+	// delete policy
+	deletesPendingMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subSystem,
+		Name:      "deletes_pending",
+		Help:      "number of deletes this replica holds for the delete policy grace period",
+	})
+	deleteBreakerOpenMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subSystem,
+		Name:      "delete_breaker_open",
+		Help:      "1 while the delete circuit breaker is open and the deletes are paused",
+	})
+	tombstonesCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subSystem,
+			Name:      "tombstones_count",
+			Help:      "count of destination objects moved to the tombstone prefix",
+		},
+		[]string{"destination"},
+	)
+
+	manifestFlushesCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subSystem,
+			Name:      "manifest_flushes_count",
+			Help:      "count of manifest objects written or failed to write",
+		},
+		[]string{"result"},
+	)
+
+	// reconcile
+	reconcileObjectsMetric = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      "reconcile_objects",
+			Help:      "number of objects that are missing, extra, or differ by size or etag from the last reconcile",
+		},
+		[]string{"destination", "difference"},
+	)
+	reconcileSourceObjectsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subSystem,
+		Name:      "reconcile_source_objects",
+		Help:      "number of source objects listed by the last reconcile",
+	})
+	reconcileDurationMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subSystem,
+		Name:      "reconcile_duration",
+		Help:      "duration of the last reconcile in seconds",
+	})
+	reconcileLastRunMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: subSystem,
+		Name:      "reconcile_last_run_timestamp",
+		Help:      "unix timestamp of when the last reconcile finished",
+	})
 )
 
 func (a *Archiver) countMessagesProcessedMetric(state string, error string, code string, event string, eventType string) {
 	messagesProcessedCount.WithLabelValues(state, error, code, event, eventType).Inc()
 }
-func (a *Archiver) observeMessagesTransferDurationMetric(seconds float64) {
-	messagesTransferDuration.Observe(seconds)
+func (a *Archiver) observeMessagesTransferDurationMetric(destination string, storageClass string, seconds float64) {
+	messagesTransferDuration.WithLabelValues(destination, storageClassLabel(storageClass)).Observe(seconds)
 }
-func (a *Archiver) observeMessagesTransferRateMetric(bytes float64) {
+func (a *Archiver) observeMessagesTransferRateMetric(destination string, storageClass string, bytes float64) {
 	kBytes := bytes / 1000
-	messagesTransferRateMetric.Observe(kBytes)
+	messagesTransferRateMetric.WithLabelValues(destination, storageClassLabel(storageClass)).Observe(kBytes)
 }
-func (a *Archiver) observeMessagesTransferSizeMetric(bytes float64) {
+func (a *Archiver) observeMessagesTransferSizeMetric(destination string, storageClass string, bytes float64) {
 	kBytes := bytes / 1000
-	messagesTransferSizeMetric.Observe(kBytes)
+	messagesTransferSizeMetric.WithLabelValues(destination, storageClassLabel(storageClass)).Observe(kBytes)
 }
 func (a *Archiver) observeMessagesTransferNumDeliveredMetric(count float64) {
 	messagesTransferNumDeliveredMetric.Observe(count)
@@ -94,8 +170,8 @@ func (a *Archiver) observeMessagesTransferNumDeliveredMetric(count float64) {
 func (a *Archiver) observeMessagesTransferQueueDurationMetric(seconds float64) {
 	messagesTransferQueueDurationMetric.Observe(seconds)
 }
-func (a *Archiver) observeMessagesDeleteDurationMetric(seconds float64) {
-	messagesDeleteDuration.Observe(seconds)
+func (a *Archiver) observeMessagesDeleteDurationMetric(destination string, seconds float64) {
+	messagesDeleteDuration.WithLabelValues(destination).Observe(seconds)
 }
 func (a *Archiver) observeMessagesDeleteNumDeliveredMetric(count float64) {
 	messagesDeleteNumDeliveredMetric.Observe(count)
@@ -104,6 +180,31 @@ func (a *Archiver) observeMessagesDeleteQueueDurationMetric(seconds float64) {
 	messagesDeleteQueueDurationMetric.Observe(seconds)
 }
 
+func (a *Archiver) countChecksumVerificationsMetric(destination string, result string) {
+	checksumVerificationsCount.WithLabelValues(destination, result).Inc()
+}
+
+func (a *Archiver) countTombstonesMetric(destination string) {
+	tombstonesCount.WithLabelValues(destination).Inc()
+}
+
+func (a *Archiver) countManifestFlushesMetric(result string) {
+	manifestFlushesCount.WithLabelValues(result).Inc()
+}
+
+func (a *Archiver) setReconcileObjectsMetric(destination string, difference string, count float64) {
+	reconcileObjectsMetric.WithLabelValues(destination, difference).Set(count)
+}
+func (a *Archiver) setReconcileSourceObjectsMetric(count float64) {
+	reconcileSourceObjectsMetric.Set(count)
+}
+func (a *Archiver) setReconcileDurationMetric(seconds float64) {
+	reconcileDurationMetric.Set(seconds)
+}
+func (a *Archiver) setReconcileLastRunMetric(timestamp float64) {
+	reconcileLastRunMetric.Set(timestamp)
+}
+
 func (a *Archiver) cleanupAndCountMessagesProcessedMetric(state string, error string, code string, event string, eventType string) {
 	// remove any URLs from the error output
 	urlRegex := regexp.MustCompile(`((")?http(s)?://[\w.\-/?=&:"_]+)`)
@@ -135,3 +236,11 @@ func (a *Archiver) cleanupAndCountMessagesProcessedMetric(state string, error st
 
 	a.countMessagesProcessedMetric(state, error, code, event, eventType)
 }
+
+// the bucket's default class has no name of its own
+func storageClassLabel(storageClass string) string {
+	if storageClass == "" {
+		return "default"
+	}
+	return storageClass
+}
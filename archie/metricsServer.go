@@ -9,9 +9,11 @@ import (
 
 func (a *Archiver) StartMetricsServer(port int) *http.Server {
 
-	srv := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+	// the metrics server only serves its own handlers, not the ones of the other servers
+	a.metricsMux = http.NewServeMux()
+	a.metricsMux.Handle("/metrics", promhttp.Handler())
 
-	http.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: a.metricsMux}
 
 	go func() {
 		defer func() {
@@ -1,9 +1,11 @@
 package archie
 
 import (
+	"archie/client"
 	"context"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
+	"sync"
 	"time"
 )
 
@@ -19,23 +21,46 @@ func (a *Archiver) MessageProcessor(baseCtx context.Context, msgCtx context.Cont
 	}()
 	a.WaitGroup.Add(1)
 
+	workers := a.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// unbuffered so fetching blocks until a worker is free
+	msgQueue := make(chan *nats.Msg)
+	workerWaitGroup := &sync.WaitGroup{}
+
+	for id := 1; id <= workers; id++ {
+		workerWaitGroup.Add(1)
+		go a.messageWorker(id, msgCtx, msgTimeout, msgQueue, workerWaitGroup)
+	}
+
+	log.Info().Msgf("Started %d message workers", workers)
+
+	// blocking
+	a.fetchMessages(baseCtx, sub, batchSize, msgQueue)
+
+	// let the workers finish their in-flight messages
+	close(msgQueue)
+	workerWaitGroup.Wait()
+
+	a.FetchDone <- "graceful"
+}
+
+func (a *Archiver) fetchMessages(baseCtx context.Context, sub *nats.Subscription, batchSize int, msgQueue chan<- *nats.Msg) {
 	for {
-		// wait until both clients are online to fetch new messages from jetstream
-		if a.SrcClient.IsOffline() || a.DestClient.IsOffline() {
+		// wait until all clients are online to fetch new messages from jetstream
+		if offlineClient := a.offlineClient(); offlineClient != nil {
 			// only log on state change
 			if a.IsOffline == false {
-				if a.SrcClient.IsOffline() {
-					log.Info().Msgf("Waiting while %s is offline", a.SrcClient.EndpointURL())
-				} else {
-					log.Info().Msgf("Waiting while %s is offline", a.DestClient.EndpointURL())
-				}
+				log.Info().Msgf("Waiting while %s is offline", offlineClient.EndpointURL())
 			}
 			a.IsOffline = true
 			time.Sleep(time.Second * 10)
 			continue
 		}
 
-		// source and dest must be online
+		// source and destinations must be online
 		a.IsOffline = false
 
 		// fetch will stop (error forever) if the context is canceled
@@ -47,7 +72,6 @@ func (a *Archiver) MessageProcessor(baseCtx context.Context, msgCtx context.Cont
 			} else if err == context.Canceled {
 				// base context canceled - graceful shutdown signal
 				log.Info().Msg("Stopping event pull processing")
-				a.FetchDone <- "graceful"
 				return
 			} else {
 				log.Error().Err(err).Msg("Failed to fetch a new batch of JetStream messages")
@@ -56,26 +80,62 @@ func (a *Archiver) MessageProcessor(baseCtx context.Context, msgCtx context.Cont
 		}
 
 		for _, msg := range msgs {
-			// check for each message in the batch if we are processing more than one
-			if batchSize > 1 && checkContextDone(baseCtx) {
+			if checkContextDone(baseCtx) {
 				log.Info().Msg("Stopping event pull processing")
-				a.FetchDone <- "graceful"
 				return
 			}
 
-			// wrap this so we can use defer()
-			func() {
-				// create message sub-context with a timeout
-				// that will cancel the transfer immediately
-				perMsgCtx, perMsgCancel := context.WithTimeout(msgCtx, msgTimeout)
-				defer func() {
-					log.Trace().Msg("Deferred individual message context canceled")
-					perMsgCancel()
-				}()
-
-				// main message func
-				a.message(perMsgCtx, msg)
-			}()
+			// hand off each message in the batch to the next free worker,
+			// unsent messages are redelivered by the server after their ack wait
+			select {
+			case msgQueue <- msg:
+			case <-baseCtx.Done():
+				log.Info().Msg("Stopping event pull processing")
+				return
+			}
+		}
+	}
+}
+
+// the first offline client, source then destinations
+func (a *Archiver) offlineClient() client.Client {
+	if a.SrcClient.IsOffline() {
+		return a.SrcClient
+	}
+	for _, dest := range a.Destinations {
+		if dest.Client.IsOffline() {
+			return dest.Client
 		}
 	}
+	return nil
+}
+
+func (a *Archiver) messageWorker(id int, msgCtx context.Context, msgTimeout time.Duration, msgQueue <-chan *nats.Msg, workerWaitGroup *sync.WaitGroup) {
+	defer func() {
+		log.Trace().Int("worker", id).Msg("Deferred message worker done")
+		workerWaitGroup.Done()
+	}()
+
+	// per-worker context, canceled with the msg context to stop active transfers immediately
+	workerCtx, workerCancel := context.WithCancel(msgCtx)
+	defer func() {
+		log.Trace().Int("worker", id).Msg("Deferred message worker context canceled")
+		workerCancel()
+	}()
+
+	for msg := range msgQueue {
+		// wrap this so we can use defer()
+		func() {
+			// create message sub-context with a timeout
+			// that will cancel the transfer immediately
+			perMsgCtx, perMsgCancel := context.WithTimeout(workerCtx, msgTimeout)
+			defer func() {
+				log.Trace().Msg("Deferred individual message context canceled")
+				perMsgCancel()
+			}()
+
+			// main message func
+			a.message(perMsgCtx, msg)
+		}()
+	}
 }
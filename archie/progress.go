@@ -0,0 +1,88 @@
+package archie
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+)
+
+// progress keeps the names of the destinations that already finished a message,
+// so a redelivery after a partial failure only retries the failed destinations
+func progressKey(metadata *nats.MsgMetadata) string {
+	return fmt.Sprintf("%s.%d", metadata.Consumer, metadata.Sequence.Stream)
+}
+
+func (a *Archiver) pendingDestinations(mLog zerolog.Logger, metadata *nats.MsgMetadata, destinations []*Destination) ([]*Destination, []string) {
+	if a.ProgressKV == nil {
+		return destinations, nil
+	}
+
+	entry, err := a.ProgressKV.Get(progressKey(metadata))
+	if err != nil {
+		if err != nats.ErrKeyNotFound {
+			// worst case the finished destinations are written again
+			mLog.Error().Err(err).Msg("Failed to get the destination progress")
+		}
+		return destinations, nil
+	}
+
+	var done []string
+	err = json.Unmarshal(entry.Value(), &done)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to unmarshal the destination progress")
+		return destinations, nil
+	}
+
+	var pending []*Destination
+	for _, dest := range destinations {
+		if !slices.Contains(done, dest.Name) {
+			pending = append(pending, dest)
+		}
+	}
+
+	if len(done) > 0 {
+		mLog.Info().Strs("done", done).Msg("Skipping destinations that already finished")
+	}
+
+	return pending, done
+}
+
+func (a *Archiver) saveProgress(mLog zerolog.Logger, metadata *nats.MsgMetadata, done []string) {
+	if a.ProgressKV == nil || len(done) == 0 {
+		return
+	}
+
+	doneJSON, err := json.Marshal(done)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to marshal the destination progress")
+		return
+	}
+
+	_, err = a.ProgressKV.Put(progressKey(metadata), doneJSON)
+	if err != nil {
+		mLog.Error().Err(err).Msg("Failed to save the destination progress")
+	}
+}
+
+func (a *Archiver) clearProgress(mLog zerolog.Logger, metadata *nats.MsgMetadata) {
+	if a.ProgressKV == nil {
+		return
+	}
+
+	// purge also drops the history so the key doesn't linger
+	err := a.ProgressKV.Purge(progressKey(metadata))
+	if err != nil && err != nats.ErrKeyNotFound {
+		mLog.Error().Err(err).Msg("Failed to clear the destination progress")
+	}
+}
+
+// DestinationNames are the names of the destinations for the logs
+func DestinationNames(destinations []*Destination) []string {
+	names := make([]string, len(destinations))
+	for i, dest := range destinations {
+		names[i] = dest.Name
+	}
+	return names
+}
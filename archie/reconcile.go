@@ -0,0 +1,320 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+// how often the replicas check if a reconcile is due
+const reconcileCheckInterval = time.Minute
+
+type ReconcileOptions struct {
+	Interval      time.Duration
+	LockKV        nats.KeyValue
+	MaxReportKeys int
+	Prefix        string
+
+	// enqueue copy events for the missing and differing objects to the subject
+	Repair bool
+	// enqueue remove events for the extra destination objects too
+	RepairExtra bool
+	Subject     string
+}
+
+// ReconcileReport's Skipped source objects are the ones the exclude and include paths or the filters leave out,
+// with ExtraUnchecked the destinations were stat'd by key instead of listed so their extra objects weren't looked for
+type ReconcileReport struct {
+	Bucket         string                                 `json:"bucket"`
+	Destinations   map[string]*ReconcileDestinationReport `json:"destinations"`
+	Duration       string                                 `json:"duration"`
+	Error          string                                 `json:"error,omitempty"`
+	ExtraUnchecked bool                                   `json:"extraUnchecked"`
+	Finished       time.Time                              `json:"finished"`
+	Prefix         string                                 `json:"prefix"`
+	Skipped        uint64                                 `json:"skipped"`
+	SourceObjects  uint64                                 `json:"sourceObjects"`
+	Started        time.Time                              `json:"started"`
+}
+
+type ReconcileDestinationReport struct {
+	Counts      map[string]uint64     `json:"counts"`
+	Differences []ReconcileDifference `json:"differences"`
+	Repaired    uint64                `json:"repaired"`
+	Truncated   bool                  `json:"truncated"`
+}
+
+type ReconcileDifference struct {
+	DestETag   string `json:"destETag,omitempty"`
+	DestSize   int64  `json:"destSize,omitempty"`
+	Difference string `json:"difference"`
+	Key        string `json:"key"`
+	SrcETag    string `json:"srcETag,omitempty"`
+	SrcSize    int64  `json:"srcSize,omitempty"`
+}
+
+// StartReconciler periodically compares the source and destination buckets until the context is canceled,
+// the last report is served as json on /reconcile of the metrics server
+func (a *Archiver) StartReconciler(ctx context.Context, opts ReconcileOptions) {
+	a.metricsMux.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		report := a.reconcileReport.Load()
+		if report == nil {
+			http.Error(w, "no reconcile has finished yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+
+	go func() {
+		ticker := time.NewTicker(reconcileCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if a.claimReconcile(opts) {
+				a.Reconcile(ctx, opts)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Info().Msgf("Started the reconciler with a %s interval", opts.Interval)
+}
+
+// only one replica reconciles each interval, the last start time is swapped in the key-value bucket
+func (a *Archiver) claimReconcile(opts ReconcileOptions) bool {
+	key := "reconcile." + base64.RawURLEncoding.EncodeToString([]byte(a.SrcBucket+"/"+opts.Prefix))
+	now := time.Now().UTC()
+
+	entry, err := opts.LockKV.Get(key)
+	if err == nats.ErrKeyNotFound {
+		_, err = opts.LockKV.Create(key, []byte(now.Format(time.RFC3339Nano)))
+		return err == nil
+	} else if err != nil {
+		log.Error().Err(err).Msg("Failed to get the last reconcile time")
+		return false
+	}
+
+	lastRun, err := time.Parse(time.RFC3339Nano, string(entry.Value()))
+	if err == nil && now.Sub(lastRun) < opts.Interval {
+		return false
+	}
+
+	// fails when another replica claimed it first
+	_, err = opts.LockKV.Update(key, []byte(now.Format(time.RFC3339Nano)), entry.Revision())
+	return err == nil
+}
+
+// Reconcile walks the source and destination listings together and reports the differences
+func (a *Archiver) Reconcile(ctx context.Context, opts ReconcileOptions) *ReconcileReport {
+	report := &ReconcileReport{
+		Bucket:       a.SrcBucket,
+		Destinations: map[string]*ReconcileDestinationReport{},
+		Prefix:       opts.Prefix,
+		Started:      time.Now().UTC(),
+	}
+
+	log.Info().Str("prefix", opts.Prefix).Msg("Reconcile started")
+
+	err := a.reconcile(ctx, opts, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	report.Finished = time.Now().UTC()
+	report.Duration = report.Finished.Sub(report.Started).String()
+
+	summary := map[string]map[string]uint64{}
+	for name, destReport := range report.Destinations {
+		summary[name] = destReport.Counts
+	}
+
+	if err != nil {
+		// a partial listing would under-report, so the metrics keep the last complete run
+		log.Error().Err(err).Uint64("sourceObjects", report.SourceObjects).Interface("differences", summary).Msg("Reconcile failed")
+	} else {
+		for name, destReport := range report.Destinations {
+			for _, difference := range []string{DifferenceMissing, DifferenceExtra, DifferenceSize, DifferenceETag} {
+				a.setReconcileObjectsMetric(name, difference, float64(destReport.Counts[difference]))
+			}
+		}
+		a.setReconcileSourceObjectsMetric(float64(report.SourceObjects))
+		a.setReconcileDurationMetric(report.Finished.Sub(report.Started).Seconds())
+		a.setReconcileLastRunMetric(float64(report.Finished.Unix()))
+
+		log.Info().
+			Uint64("sourceObjects", report.SourceObjects).
+			Interface("differences", summary).
+			Str("duration", report.Duration).
+			Msg("Reconcile complete")
+	}
+
+	a.reconcileReport.Store(report)
+
+	return report
+}
+
+func (a *Archiver) reconcile(ctx context.Context, opts ReconcileOptions, report *ReconcileReport) error {
+	// canceling the listings stops their goroutines on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	srcCursor := newListCursor(a.SrcClient.ListObjects(listCtx, a.SrcBucket, opts.Prefix, client.ListOptions{}))
+	destCursors := a.destinationCursors(listCtx, opts.Prefix, client.ListOptions{}, nil)
+	// without the destination listings the extra objects aren't found
+	report.ExtraUnchecked = len(a.Destinations) > 0 && destCursors[0] == nil
+	for _, dest := range a.Destinations {
+		report.Destinations[dest.Name] = &ReconcileDestinationReport{Counts: map[string]uint64{}, Differences: []ReconcileDifference{}}
+	}
+
+	// extra objects only exist in the destination
+	extra := func(dest *Destination) func(info client.ObjectInfo) {
+		return func(info client.ObjectInfo) {
+			if a.isTombstone(info.Key) || a.isManifest(info.Key) {
+				return
+			}
+
+			repaired := false
+			if opts.Repair && opts.RepairExtra {
+				repaired = a.reconcileRepairExtra(opts, dest, info)
+			}
+			a.addReconcileDifference(opts, report.Destinations[dest.Name], ReconcileDifference{
+				DestETag:   info.ETag,
+				DestSize:   info.Size,
+				Difference: DifferenceExtra,
+				Key:        info.Key,
+			}, repaired)
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := srcCursor.next()
+		if err != nil {
+			return fmt.Errorf("failed to list the source bucket: %w", err)
+		}
+		if srcCursor.current == nil {
+			break
+		}
+
+		src := *srcCursor.current
+		report.SourceObjects++
+
+		// the objects the copy events skip aren't compared, their destination objects aren't extra either
+		skipped, err := a.listedCopySkip(ctx, src)
+		if err != nil {
+			return fmt.Errorf("failed to stat the source object %s: %w", src.Key, err)
+		}
+		if skipped != "" {
+			report.Skipped++
+			for i, dest := range a.Destinations {
+				if destCursors[i] == nil {
+					continue
+				}
+				_, err := destCursors[i].seek(src.Key, extra(dest))
+				if err != nil {
+					return fmt.Errorf("failed to list the %s destination bucket: %w", dest.Name, err)
+				}
+			}
+			continue
+		}
+
+		// the copy event covers every destination so it's only enqueued once
+		repaired := false
+		repairTried := false
+
+		route := a.route(src.Key)
+
+		for i, dest := range a.Destinations {
+			if !route.copies(dest) {
+				continue
+			}
+
+			destInfo, err := a.destinationObject(ctx, dest, destCursors[i], src, extra(dest))
+			if err != nil {
+				return fmt.Errorf("failed to list the %s destination bucket: %w", dest.Name, err)
+			}
+
+			difference := a.objectDifference(src, destInfo)
+			if difference == "" {
+				continue
+			}
+
+			if opts.Repair && !repairTried {
+				repaired = a.reconcileRepair(opts, "s3:ObjectCreated:Put", src, nil)
+				repairTried = true
+			}
+
+			reconcileDifference := ReconcileDifference{
+				Difference: difference,
+				Key:        src.Key,
+				SrcETag:    src.ETag,
+				SrcSize:    src.Size,
+			}
+			if destInfo != nil {
+				reconcileDifference.DestETag = destInfo.ETag
+				reconcileDifference.DestSize = destInfo.Size
+			}
+
+			a.addReconcileDifference(opts, report.Destinations[dest.Name], reconcileDifference, repaired)
+		}
+	}
+
+	for i, dest := range a.Destinations {
+		// the extra objects are only found by merging the listings
+		if destCursors[i] == nil {
+			continue
+		}
+
+		err := destCursors[i].drain(extra(dest))
+		if err != nil {
+			return fmt.Errorf("failed to list the %s destination bucket: %w", dest.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *Archiver) addReconcileDifference(opts ReconcileOptions, destReport *ReconcileDestinationReport, difference ReconcileDifference, repaired bool) {
+	destReport.Counts[difference.Difference]++
+	if repaired {
+		destReport.Repaired++
+	}
+
+	if len(destReport.Differences) < opts.MaxReportKeys {
+		destReport.Differences = append(destReport.Differences, difference)
+	} else {
+		destReport.Truncated = true
+	}
+}
+
+func (a *Archiver) reconcileRepair(opts ReconcileOptions, eventName string, info client.ObjectInfo, destinations []*Destination) bool {
+	err := a.enqueueEvent(opts.Subject, eventName, info, "archie-reconcile", destinations)
+	if err != nil {
+		log.Error().Err(err).Str("key", info.Key).Str("event", eventName).Msg("Failed to enqueue the reconcile repair event")
+		return false
+	}
+	log.Debug().Str("key", info.Key).Str("event", eventName).Msg("Reconcile repair event enqueued")
+	return true
+}
+
+// the remove event of an extra object only goes to the destination it's extra in, the extra objects are only
+// found without key rules or compression so the destination key is the source key
+func (a *Archiver) reconcileRepairExtra(opts ReconcileOptions, dest *Destination, info client.ObjectInfo) bool {
+	return a.reconcileRepair(opts, "s3:ObjectRemoved:Delete", info, []*Destination{dest})
+}
@@ -0,0 +1,58 @@
+package archie
+
+import (
+	"context"
+	"go.arsenm.dev/pcre"
+	"testing"
+)
+
+func TestReconcileSkipped(t *testing.T) {
+	src := testDestination(t)
+	dest := testDestination(t)
+
+	a := &Archiver{Destinations: []*Destination{dest}, SrcBucket: src.Bucket, SrcClient: src.Client}
+	a.ExcludePaths.CopyObject = []*pcre.Regexp{pcre.MustCompile(`^excluded/`)}
+
+	putTestObject(t, src, "a.txt", nil)
+	putTestObject(t, dest, "a.txt", nil)
+	putTestObject(t, src, "excluded/b.txt", nil)
+	// copied before the path was excluded
+	putTestObject(t, src, "excluded/c.txt", nil)
+	putTestObject(t, dest, "excluded/c.txt", nil)
+	putTestObject(t, dest, "extra.txt", nil)
+
+	report := a.Reconcile(context.Background(), ReconcileOptions{MaxReportKeys: 10})
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if report.Skipped != 2 || report.ExtraUnchecked {
+		t.Errorf("report %+v, want 2 skipped with the extra objects checked", report)
+	}
+
+	counts := report.Destinations[dest.Name].Counts
+	if counts[DifferenceMissing] != 0 || counts[DifferenceExtra] != 1 {
+		t.Errorf("differences %v, want only the extra object", counts)
+	}
+}
+
+func TestReconcileExtraUnchecked(t *testing.T) {
+	src := testDestination(t)
+	dest := testDestination(t)
+
+	a := &Archiver{
+		Destinations: []*Destination{dest},
+		KeyRules:     []KeyRule{{AddPrefix: "archive/"}},
+		SrcBucket:    src.Bucket,
+		SrcClient:    src.Client,
+	}
+
+	putTestObject(t, dest, "extra.txt", nil)
+
+	report := a.Reconcile(context.Background(), ReconcileOptions{MaxReportKeys: 10})
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if !report.ExtraUnchecked {
+		t.Error("the report doesn't say the extra objects weren't looked for")
+	}
+}
@@ -1,6 +1,7 @@
 package archie
 
 import (
+	"archie/client"
 	"archie/event"
 	"context"
 	"github.com/nats-io/nats.go"
@@ -41,21 +42,119 @@ func (a *Archiver) removeObject(ctx context.Context, mLog zerolog.Logger, eventO
 		}
 	}
 
-	start := time.Now()
+	// an exclude match wins over an include match
+	if !included(a.IncludePaths.RemoveObject, eventObjKey) {
+		mLog.Info().
+			Uint64("numDelivered", metadata.NumDelivered).
+			Str("queueDuration", time.Now().Sub(metadata.Timestamp).String()).
+			Msg("No include path match, remove event skipped")
+
+		a.observeMessagesDeleteNumDeliveredMetric(float64(metadata.NumDelivered))
+		a.observeMessagesDeleteQueueDurationMetric(time.Now().Sub(metadata.Timestamp).Seconds())
+
+		return nil, "NOT_INCLUDED_PATH", SkipAck
+	}
+
+	route := a.route(eventObjKey)
+	if route.CopyOnly {
+		mLog.Info().Str("route", route.Name).Msg("Copy only route, remove event skipped")
+		return nil, "ROUTE_COPY_ONLY", SkipAck
+	}
 
-	err := a.DestClient.RemoveObject(ctx, a.DestBucket, eventObjKey)
+	destinations, done := a.pendingDestinations(mLog, metadata, eventDestinations(msg, route.Destinations))
+	if len(destinations) == 0 {
+		// every destination finished on an earlier delivery
+		return nil, "", Ack
+	}
+
+	if a.DryRun {
+		mLog.Info().Strs("destinations", DestinationNames(destinations)).Msg("Dry run, delete skipped")
+		return nil, "DRY_RUN", SkipAck
+	}
+
+	// a delete with a version id permanently deleted that version, without one or for a
+	// delete marker only the latest version was hidden
+	var versionID string
+	if record.EventName == "s3:ObjectRemoved:Delete" {
+		versionID = record.S3.Object.VersionID
+	}
+
+	switch a.DeletePolicy.Mode {
+	case DeletePolicyIgnore:
+		mLog.Info().Msg("Delete policy ignores deletes, remove event skipped")
+		return nil, "DELETE_IGNORED", SkipAck
+	case DeletePolicyDelay:
+		if a.delayDelete(mLog, msg, metadata) {
+			return nil, "DELETE_DELAYED", Delayed
+		}
+
+		// the delete is dropped when the object came back to the source during the grace period
+		if versionID == "" {
+			_, err := a.SrcClient.StatObject(ctx, a.SrcBucket, eventObjKey, client.GetOptions{})
+			if err == nil {
+				mLog.Info().Msg("Source object was recreated during the grace period, delete skipped")
+				return nil, "SOURCE_RECREATED", SkipAck
+			} else if !isObjectNotFound(err) {
+				return err, "Failed to Stat the source object", Nak
+			}
+		}
+	case DeletePolicyTombstone:
+		if versionID != "" {
+			mLog.Info().Msg("Tombstone policy keeps the destination versions, version delete skipped")
+			return nil, "DELETE_IGNORED", SkipAck
+		}
+	}
+
+	err := a.checkDeleteBreaker(ctx, mLog, eventObjKey, route)
 	if err != nil {
-		if err.Error() == "The specified key does not exist." {
-			// minio error
-			return err, "Failed to RemoveObject from destination bucket", NakThenTerm
-		} else if err.Error() == "storage: object doesn't exist" {
-			// gcs error
-			return err, "Failed to RemoveObject from destination bucket", NakThenTerm
-		} else {
-			return err, "Failed to RemoveObject from destination bucket", Nak
+		return err, "DELETE_BREAKER_OPEN", Nak
+	}
+
+	start := time.Now()
+
+	var removeErr error
+	removeAck := Ack
+	for _, dest := range destinations {
+		destStart := time.Now()
+
+		err := a.removeDestination(ctx, mLog, dest, eventObjKey, versionID)
+		if err != nil {
+			if len(destinations) > 1 {
+				mLog.Error().Err(err).Str("destination", dest.Name).Msg("Delete failed")
+			}
+			if removeErr == nil {
+				removeErr = err
+			}
+			// a missing object only terminates when no other destination needs a plain retry
+			if isObjectNotFound(err) {
+				if removeAck == Ack {
+					removeAck = NakThenTerm
+				}
+			} else {
+				removeAck = Nak
+			}
+			continue
 		}
+
+		done = append(done, dest.Name)
+		a.observeMessagesDeleteDurationMetric(dest.Name, time.Now().Sub(destStart).Seconds())
+	}
+
+	if removeErr != nil {
+		// only the failed destinations are retried
+		a.saveProgress(mLog, metadata, done)
+		return removeErr, "Failed to RemoveObject from destination bucket", removeAck
 	}
 
+	a.clearProgress(mLog, metadata)
+	a.recordManifest(ManifestEntry{
+		Destinations: done,
+		Key:          eventObjKey,
+		Op:           ManifestDelete,
+		Time:         record.EventTime,
+		VersionID:    versionID,
+	})
+
 	// measure delete time
 	deleteElapsed := time.Now().Sub(start)
 
@@ -70,9 +169,37 @@ func (a *Archiver) removeObject(ctx context.Context, mLog zerolog.Logger, eventO
 		Msg("Delete complete")
 
 	// successful delete metrics
-	a.observeMessagesDeleteDurationMetric(deleteElapsed.Seconds())
 	a.observeMessagesDeleteNumDeliveredMetric(float64(metadata.NumDelivered))
 	a.observeMessagesDeleteQueueDurationMetric(queueDuration.Seconds())
 
 	return nil, "", Ack
 }
+
+// remove the object from a destination under each of its rewritten keys, with a version id
+// only the destination versions copied from that source version are removed
+func (a *Archiver) removeDestination(ctx context.Context, mLog zerolog.Logger, dest *Destination, key string, versionID string) error {
+	destKeys, err := a.destinationKeys(ctx, dest, key)
+	if err != nil {
+		return err
+	}
+
+	for _, destKey := range destKeys {
+		if versionID != "" {
+			err = a.removeVersion(ctx, mLog, dest, destKey, versionID)
+		} else if a.DeletePolicy.Mode == DeletePolicyTombstone {
+			err = a.tombstoneObject(ctx, mLog, dest, destKey)
+		} else {
+			// a versioned destination gets its own delete marker
+			err = dest.Client.RemoveObject(ctx, dest.Bucket, destKey, client.RemoveOptions{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// the versions left behind keep their keys
+	if versionID == "" {
+		return a.clearIndexedKeys(dest, key)
+	}
+	return nil
+}
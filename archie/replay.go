@@ -0,0 +1,122 @@
+package archie
+
+import (
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type ReplayOptions struct {
+	BatchSize        int
+	EndSequence      uint64
+	EndTime          time.Time
+	RepublishSubject string
+}
+
+// ReplayResult counts the replayed messages, the replay delivers each message once so the failed ones
+// are only retried by replaying them again
+type ReplayResult struct {
+	Failed     uint64 `json:"failed"`
+	Replayed   uint64 `json:"replayed"`
+	Terminated uint64 `json:"terminated"`
+}
+
+// Replay reads the messages from the replay subscription until the end sequence or time is passed,
+// each message is either republished to the archie subject or processed like a live message
+func (a *Archiver) Replay(ctx context.Context, sub *nats.Subscription, opts ReplayOptions) (ReplayResult, error) {
+	var replayed ReplayResult
+
+	msgTimeout, err := time.ParseDuration(a.MsgTimeout)
+	if err != nil {
+		return replayed, err
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return replayed, ctx.Err()
+		}
+
+		msgs, err := sub.Fetch(opts.BatchSize, nats.MaxWait(5*time.Second))
+		if errors.Is(err, nats.ErrTimeout) {
+			// the fetch is only empty when there's nothing left in range
+			consumerInfo, err := sub.ConsumerInfo()
+			if err != nil {
+				return replayed, err
+			}
+			if consumerInfo.NumPending == 0 {
+				return replayed, nil
+			}
+			continue
+		} else if err != nil {
+			return replayed, err
+		}
+
+		for _, msg := range msgs {
+			metadata, err := msg.Metadata()
+			if err != nil {
+				return replayed, err
+			}
+
+			if opts.EndSequence > 0 && metadata.Sequence.Stream > opts.EndSequence {
+				return replayed, nil
+			}
+			if !opts.EndTime.IsZero() && metadata.Timestamp.After(opts.EndTime) {
+				return replayed, nil
+			}
+
+			rLog := log.With().Uint64("seq", metadata.Sequence.Stream).Str("subject", msg.Subject).Logger()
+
+			if opts.RepublishSubject != "" {
+				if a.DryRun {
+					rLog.Info().Str("republishSubject", opts.RepublishSubject).Msg("Dry run, republish skipped")
+				} else {
+					err = a.republish(msg, opts.RepublishSubject)
+					if err != nil {
+						return replayed, err
+					}
+					rLog.Info().Str("republishSubject", opts.RepublishSubject).Msg("Message republished")
+				}
+
+				err = sendAckSignal(msg, &rLog)
+				if err != nil {
+					return replayed, err
+				}
+				replayed.Replayed++
+			} else {
+				msgCtx, msgCancel := context.WithTimeout(ctx, msgTimeout)
+				sent := a.message(msgCtx, msg)
+				msgCancel()
+
+				switch sent {
+				case Nak, Delayed:
+					rLog.Error().Msg("Replayed message failed, it isn't redelivered")
+					replayed.Failed++
+				case Term:
+					replayed.Terminated++
+				default:
+					replayed.Replayed++
+				}
+			}
+
+			if opts.EndSequence > 0 && metadata.Sequence.Stream >= opts.EndSequence {
+				return replayed, nil
+			}
+		}
+	}
+}
+
+func (a *Archiver) republish(msg *nats.Msg, subject string) error {
+	replayMsg := nats.NewMsg(subject)
+	replayMsg.Data = msg.Data
+
+	// the dead letter details don't belong on the replayed event, the destinations it's scoped to do
+	copyHeaders(msg, replayMsg, "Archie-")
+	if destinations := msg.Header.Get(destinationsHeader); destinations != "" {
+		replayMsg.Header.Set(destinationsHeader, destinations)
+	}
+
+	_, err := a.JetStream.PublishMsg(replayMsg)
+	return err
+}
@@ -0,0 +1,325 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/compression"
+	"archie/encryption"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restore outcomes of an object
+const (
+	restoreExists   = "exists"
+	restoreFailed   = "failed"
+	restoreFiltered = "filtered"
+	restoreRestored = "restored"
+)
+
+type RestoreOptions struct {
+	// source keys to restore instead of listing the prefix
+	Keys      []string
+	Overwrite bool
+	Prefix    string
+
+	// only the destination objects written within the window, a zero time leaves that side open
+	Since time.Time
+	Until time.Time
+
+	// where the objects are restored to
+	Target       client.Client
+	TargetBucket string
+}
+
+type RestoreResult struct {
+	Exists   uint64 `json:"exists"`
+	Failed   uint64 `json:"failed"`
+	Listed   uint64 `json:"listed"`
+	Missing  uint64 `json:"missing"`
+	Restored uint64 `json:"restored"`
+	Size     uint64 `json:"size"`
+}
+
+// restoreObject is a destination object with the source key it's restored to,
+// an empty key is only known from the object's metadata
+type restoreObject struct {
+	destKey string
+	key     string
+}
+
+// Restore copies the objects of a destination back to the source or another target, undoing the key rules,
+// compression and encryption they were written with, existing target objects are kept without Overwrite
+func (a *Archiver) Restore(ctx context.Context, from *Destination, opts RestoreOptions) (RestoreResult, error) {
+	var result RestoreResult
+	resultLock := sync.Mutex{}
+
+	workers := a.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	restoreWaitGroup := &sync.WaitGroup{}
+	queue := make(chan restoreObject)
+
+	for i := 0; i < workers; i++ {
+		restoreWaitGroup.Add(1)
+		go func() {
+			defer restoreWaitGroup.Done()
+
+			for object := range queue {
+				rLog := log.With().Str("destKey", object.destKey).Logger()
+				if object.key != "" {
+					rLog = rLog.With().Str("key", object.key).Logger()
+				}
+
+				restored, outcome, err := a.restoreObject(ctx, rLog, from, object, opts)
+				if err != nil {
+					rLog.Error().Err(err).Msg("Failed to restore the object")
+				}
+
+				resultLock.Lock()
+				switch outcome {
+				case restoreFailed:
+					result.Failed++
+				case restoreExists:
+					result.Exists++
+				case restoreRestored:
+					result.Restored++
+					// an unknown original size isn't counted
+					if restored > 0 {
+						result.Size += uint64(restored)
+					}
+				}
+				resultLock.Unlock()
+			}
+		}()
+	}
+
+	err := a.restoreObjects(ctx, from, opts, queue, func(missing bool) {
+		resultLock.Lock()
+		if missing {
+			result.Missing++
+		} else {
+			result.Listed++
+		}
+		resultLock.Unlock()
+	})
+	close(queue)
+
+	restoreWaitGroup.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+// queue the destination objects to restore, the key list is looked up under the destination keys,
+// otherwise the destination is listed and its keys matched against the prefix and window
+func (a *Archiver) restoreObjects(ctx context.Context, from *Destination, opts RestoreOptions, queue chan<- restoreObject, count func(missing bool)) error {
+	if len(opts.Keys) > 0 {
+		for _, key := range opts.Keys {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			destKeys, err := a.destinationKeys(ctx, from, key)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Error().Str("key", key).Msg("The destination object of the key wasn't found")
+				count(true)
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to find the destination keys of %s: %w", key, err)
+			}
+
+			for _, destKey := range destKeys {
+				count(false)
+				select {
+				case queue <- restoreObject{destKey: destKey, key: key}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		return nil
+	}
+
+	// canceling the listing stops its goroutine on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	for info := range from.Client.ListObjects(listCtx, from.Bucket, a.listingPrefix(opts.Prefix), client.ListOptions{}) {
+		if info.Err != nil {
+			return fmt.Errorf("failed to list the %s destination bucket: %w", from.Name, info.Err)
+		}
+		if a.isTombstone(info.Key) || a.isManifest(info.Key) || !opts.archivedWithin(info.LastModified) {
+			continue
+		}
+
+		// a key rule that can't be undone leaves the key to the object's metadata
+		key, err := a.sourceKey(info.Key)
+		if err != nil && !errors.Is(err, errKeyRuleIrreversible) {
+			log.Debug().Err(err).Str("destKey", info.Key).Msg("Destination key skipped")
+			continue
+		}
+		if key != "" && !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+
+		count(false)
+		select {
+		case queue <- restoreObject{destKey: info.Key, key: key}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (o RestoreOptions) archivedWithin(lastModified time.Time) bool {
+	if !o.Since.IsZero() && lastModified.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && lastModified.After(o.Until) {
+		return false
+	}
+	return true
+}
+
+// restore a destination object to its source key, the restored size and the outcome are returned
+func (a *Archiver) restoreObject(ctx context.Context, rLog zerolog.Logger, from *Destination, object restoreObject, opts RestoreOptions) (int64, string, error) {
+	destObject, err := from.Client.GetObject(ctx, from.Bucket, object.destKey, client.GetOptions{})
+	if err != nil {
+		return 0, restoreFailed, err
+	}
+
+	reader := destObject.GetReader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	destStat, err := destObject.Stat(ctx)
+	if err != nil {
+		return 0, restoreFailed, err
+	}
+	if !opts.archivedWithin(destStat.LastModified) {
+		return 0, restoreFiltered, nil
+	}
+
+	// the recorded source key wins over the undone key rules
+	key := object.key
+	if recorded := client.MetadataValue(destStat.UserMetadata, client.SourceKeyMetadata); recorded != "" {
+		key, err = url.PathUnescape(recorded)
+		if err != nil {
+			return 0, restoreFailed, fmt.Errorf("failed to unescape the recorded source key: %w", err)
+		}
+		if len(opts.Keys) == 0 && !strings.HasPrefix(key, opts.Prefix) {
+			return 0, restoreFiltered, nil
+		}
+		rLog = rLog.With().Str("key", key).Logger()
+	}
+	if key == "" {
+		return 0, restoreFailed, fmt.Errorf("the source key wasn't recorded and %w", errKeyRuleIrreversible)
+	}
+
+	// the original size is recorded when the object was compressed or encrypted
+	restoreSize := destStat.Size
+	codec := client.MetadataValue(destStat.UserMetadata, compression.CodecMetadata)
+	encrypted := encryption.IsEncrypted(destStat.UserMetadata)
+	if codec != "" || encrypted {
+		restoreSize, err = strconv.ParseInt(client.MetadataValue(destStat.UserMetadata, client.OriginalSizeMetadata), 10, 64)
+		if err != nil {
+			restoreSize = -1
+		}
+	}
+
+	if !opts.Overwrite {
+		_, err = opts.Target.StatObject(ctx, opts.TargetBucket, key, client.GetOptions{})
+		if err == nil {
+			rLog.Info().Msg("The target object exists, restore skipped")
+			return 0, restoreExists, nil
+		} else if !isObjectNotFound(err) {
+			return 0, restoreFailed, err
+		}
+	}
+
+	if a.DryRun {
+		rLog.Info().
+			Int64("size", restoreSize).
+			Str("hSize", size(restoreSize)).
+			Str("compression", codec).
+			Bool("encrypted", encrypted).
+			Msg("Dry run, restore skipped")
+
+		return restoreSize, restoreRestored, nil
+	}
+
+	// decrypt then decompress, the reverse of the way out
+	if encrypted {
+		if a.Encryption == nil {
+			return 0, restoreFailed, errors.New("the object is encrypted but no encryption key file is configured")
+		}
+		reader, err = a.Encryption.Decrypt(reader, destStat.UserMetadata)
+		if err != nil {
+			return 0, restoreFailed, err
+		}
+	}
+	if codec != "" {
+		decompressed, err := compression.Decompress(reader, codec)
+		if err != nil {
+			return 0, restoreFailed, err
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+
+	putOpts := client.PutOptions{
+		CacheControl:       destStat.CacheControl,
+		ContentDisposition: destStat.ContentDisposition,
+		ContentEncoding:    destStat.ContentEncoding,
+		ContentLanguage:    destStat.ContentLanguage,
+		ContentType:        destStat.ContentType,
+		NumThreads:         from.Threads,
+		PartSize:           1024 * 1024 * from.PartSize,
+		Tags:               destStat.Tags,
+		UserMetadata:       restoredMetadata(destStat.UserMetadata),
+	}
+
+	start := time.Now()
+	_, err = opts.Target.PutObject(ctx, opts.TargetBucket, key, reader, restoreSize, putOpts)
+	if err != nil {
+		return 0, restoreFailed, err
+	}
+	elapsed := time.Now().Sub(start)
+
+	rLog.Info().
+		Int64("size", restoreSize).
+		Str("hSize", size(restoreSize)).
+		Str("transferDuration", elapsed.String()).
+		Str("rate", rate(restoreSize, elapsed.Seconds())).
+		Msg("Restore complete")
+
+	return restoreSize, restoreRestored, nil
+}
+
+// the user metadata of a restored object, without the metadata archie recorded on the destination
+func restoredMetadata(metadata map[string]string) map[string]string {
+	restored := map[string]string{}
+	for name, value := range metadata {
+		if client.IsInternalMetadata(name) {
+			continue
+		}
+		restored[name] = value
+	}
+	return restored
+}
@@ -0,0 +1,83 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/compression"
+	"go.arsenm.dev/pcre"
+	"golang.org/x/exp/slices"
+	"time"
+)
+
+// Route sends the keys matching its pattern to its destinations, the default route has no pattern
+type Route struct {
+	Compression       string
+	CompressionSuffix bool
+	CopyOnly          bool
+	DeleteOnly        bool
+	Destinations      []*Destination
+	LegalHold         bool
+	Name              string
+	Pattern           *pcre.Regexp
+	RetentionMode     string
+	RetentionPeriod   time.Duration
+	StorageClass      string
+	// StorageClasses overrides the storage class by destination name, the class names differ between backends
+	StorageClasses map[string]string
+}
+
+// find the first route matching the key, the default route when none does
+func (a *Archiver) route(key string) *Route {
+	for _, route := range a.Routes {
+		if route.Pattern.MatchString(key) {
+			return route
+		}
+	}
+
+	if a.DefaultRoute == nil {
+		return &Route{Name: "default", Destinations: a.Destinations}
+	}
+	return a.DefaultRoute
+}
+
+func (r *Route) copies(dest *Destination) bool {
+	return !r.DeleteOnly && slices.Contains(r.Destinations, dest)
+}
+
+// the storage class of the route's objects on the destination
+func (r *Route) storageClass(dest *Destination) string {
+	if class, ok := r.StorageClasses[dest.Name]; ok {
+		return class
+	}
+	return r.StorageClass
+}
+
+// the route's objects are locked for the retention period from when they're written
+func (r *Route) retainUntil() time.Time {
+	if r.RetentionMode == "" {
+		return time.Time{}
+	}
+	return time.Now().Add(r.RetentionPeriod).UTC()
+}
+
+// the route's objects are compressed unless they already are
+func (r *Route) compresses(key string, info *client.ObjectInfo) bool {
+	return r.Compression != "" && !compression.Compressed(key, info.ContentType, info.ContentEncoding)
+}
+
+// the suffix added to the destination keys of the compressed objects
+func (r *Route) keySuffix() string {
+	if !r.CompressionSuffix {
+		return ""
+	}
+	return compression.Suffix(r.Compression)
+}
+
+// a compressed destination object lists with its compressed size, only its stat has the original size
+func (a *Archiver) compressedRoutes() bool {
+	for _, route := range append([]*Route{a.DefaultRoute}, a.Routes...) {
+		if route != nil && route.Compression != "" {
+			return true
+		}
+	}
+	return false
+}
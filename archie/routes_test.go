@@ -0,0 +1,123 @@
+package archie
+
+import (
+	"archie/client"
+	"archie/compression"
+	"go.arsenm.dev/pcre"
+	"testing"
+	"time"
+)
+
+func TestRoute(t *testing.T) {
+	primary := &Destination{Name: "primary"}
+	cold := &Destination{Name: "cold"}
+
+	logs := &Route{Name: "logs", Pattern: pcre.MustCompile(`^logs/`), Destinations: []*Destination{cold}}
+	// the first matching route wins over the later ones
+	allLogs := &Route{Name: "all-logs", Pattern: pcre.MustCompile(`logs/`), Destinations: []*Destination{primary}}
+
+	a := &Archiver{Destinations: []*Destination{primary, cold}, Routes: []*Route{logs, allLogs}}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"logs/a.txt", "logs"},
+		{"app/logs/a.txt", "all-logs"},
+		{"images/a.png", "default"},
+	}
+
+	for _, test := range tests {
+		if got := a.route(test.key).Name; got != test.want {
+			t.Errorf("route(%q) = %s, want %s", test.key, got, test.want)
+		}
+	}
+
+	// without a configured default route the unmatched keys go to every destination
+	if got := a.route("images/a.png").Destinations; len(got) != 2 {
+		t.Errorf("the default route has %d destinations, want 2", len(got))
+	}
+
+	a.DefaultRoute = &Route{Name: "configured", Destinations: []*Destination{primary}}
+	if got := a.route("images/a.png"); got != a.DefaultRoute {
+		t.Errorf("route of an unmatched key = %s, want the configured default route", got.Name)
+	}
+}
+
+func TestRouteCopies(t *testing.T) {
+	primary := &Destination{Name: "primary"}
+	cold := &Destination{Name: "cold"}
+
+	tests := []struct {
+		route *Route
+		dest  *Destination
+		want  bool
+	}{
+		{&Route{Destinations: []*Destination{primary}}, primary, true},
+		{&Route{Destinations: []*Destination{primary}}, cold, false},
+		{&Route{Destinations: []*Destination{primary}, CopyOnly: true}, primary, true},
+		{&Route{Destinations: []*Destination{primary}, DeleteOnly: true}, primary, false},
+	}
+
+	for _, test := range tests {
+		if got := test.route.copies(test.dest); got != test.want {
+			t.Errorf("%+v copies(%s) = %t, want %t", test.route, test.dest.Name, got, test.want)
+		}
+	}
+}
+
+func TestRouteStorageClass(t *testing.T) {
+	route := &Route{StorageClass: "STANDARD_IA", StorageClasses: map[string]string{"gcs": "NEARLINE"}}
+
+	if got := route.storageClass(&Destination{Name: "gcs"}); got != "NEARLINE" {
+		t.Errorf("storageClass of the overridden destination = %s, want NEARLINE", got)
+	}
+	if got := route.storageClass(&Destination{Name: "s3"}); got != "STANDARD_IA" {
+		t.Errorf("storageClass = %s, want STANDARD_IA", got)
+	}
+	if got := (&Route{}).storageClass(&Destination{Name: "s3"}); got != "" {
+		t.Errorf("storageClass without one = %s, want the bucket default", got)
+	}
+}
+
+func TestRouteRetainUntil(t *testing.T) {
+	if got := (&Route{RetentionPeriod: time.Hour}).retainUntil(); !got.IsZero() {
+		t.Errorf("retainUntil without a mode = %s, want zero", got)
+	}
+
+	start := time.Now()
+	got := (&Route{RetentionMode: "GOVERNANCE", RetentionPeriod: 24 * time.Hour}).retainUntil()
+	if got.Before(start.Add(24*time.Hour)) || got.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("retainUntil = %s, want 24h from now", got)
+	}
+}
+
+func TestRouteCompression(t *testing.T) {
+	route := &Route{Compression: compression.Zstd, CompressionSuffix: true}
+
+	if !route.compresses("a.txt", &client.ObjectInfo{ContentType: "text/plain"}) {
+		t.Error("a text object isn't compressed")
+	}
+	if route.compresses("a.png", &client.ObjectInfo{ContentType: "image/png"}) {
+		t.Error("an already compressed object is compressed again")
+	}
+	if (&Route{}).compresses("a.txt", &client.ObjectInfo{}) {
+		t.Error("a route without compression compresses")
+	}
+
+	if got := route.keySuffix(); got != ".zst" {
+		t.Errorf("keySuffix = %q, want .zst", got)
+	}
+	if got := (&Route{Compression: compression.Gzip}).keySuffix(); got != "" {
+		t.Errorf("keySuffix without CompressionSuffix = %q, want none", got)
+	}
+
+	a := &Archiver{Routes: []*Route{{}}}
+	if a.compressedRoutes() {
+		t.Error("compressedRoutes without a compressing route")
+	}
+	a.DefaultRoute = route
+	if !a.compressedRoutes() {
+		t.Error("compressedRoutes misses the compressing default route")
+	}
+}
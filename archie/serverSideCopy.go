@@ -0,0 +1,75 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+func hasServerSideCopy(destinations []*Destination) bool {
+	for _, dest := range destinations {
+		if dest.ServerSideCopy {
+			return true
+		}
+	}
+	return false
+}
+
+// copy the object server-side to the destinations that share the source's backend and endpoint,
+// the rest and the ones that failed are returned to be streamed
+func (a *Archiver) copyServerSide(
+	ctx context.Context,
+	mLog zerolog.Logger,
+	key string,
+	destKey string,
+	srcStat *client.ObjectInfo,
+	destinations []*Destination,
+	putOpts func(dest *Destination) client.PutOptions,
+) ([]*Destination, []string) {
+	var streamed []*Destination
+	var copied []string
+
+	for _, dest := range destinations {
+		if !dest.ServerSideCopy {
+			streamed = append(streamed, dest)
+			continue
+		}
+
+		// the bytes don't pass through archie so there's no source checksum to verify
+		opts := putOpts(dest)
+		opts.Checksum = ""
+
+		start := time.Now()
+		_, err := dest.Client.CopyObject(ctx, a.SrcBucket, key, dest.Bucket, destKey, opts)
+		elapsed := time.Now().Sub(start)
+
+		if err != nil {
+			mLog.Warn().Err(err).Str("destination", dest.Name).Msg("Server-side copy failed, falling back to streaming")
+			streamed = append(streamed, dest)
+			continue
+		}
+
+		// the streamed transfer indexes it again
+		err = a.indexKey(dest, key, destKey)
+		if err != nil {
+			mLog.Warn().Err(err).Str("destination", dest.Name).Msg("Failed to index the server-side copy, falling back to streaming")
+			streamed = append(streamed, dest)
+			continue
+		}
+
+		mLog.Info().
+			Str("destination", dest.Name).
+			Int64("size", srcStat.Size).
+			Str("hSize", size(srcStat.Size)).
+			Str("transferDuration", elapsed.String()).
+			Msg("Destination server-side copy complete")
+
+		a.observeMessagesTransferDurationMetric(dest.Name, opts.StorageClass, elapsed.Seconds())
+		a.observeMessagesTransferSizeMetric(dest.Name, opts.StorageClass, float64(srcStat.Size))
+
+		copied = append(copied, dest.Name)
+	}
+
+	return streamed, copied
+}
@@ -17,6 +17,7 @@ func (a *Archiver) WaitForSignal(
 	msgCancel context.CancelFunc,
 	healthCheckSrv *http.Server,
 	metricsSrv *http.Server,
+	adminSrv *http.Server,
 ) {
 	shutdownWaitDuration, err := time.ParseDuration(shutdownWait)
 	if err != nil {
@@ -47,10 +48,10 @@ func (a *Archiver) WaitForSignal(
 				os.Exit(99)
 			}()
 
-			a.shutdown(sig, shutdownWaitDuration, baseCancel, msgCancel, healthCheckSrv, metricsSrv)
+			a.shutdown(sig, shutdownWaitDuration, baseCancel, msgCancel, healthCheckSrv, metricsSrv, adminSrv)
 			return
 		case syscall.SIGTERM:
-			a.shutdown(sig, shutdownWaitDuration, baseCancel, msgCancel, healthCheckSrv, metricsSrv)
+			a.shutdown(sig, shutdownWaitDuration, baseCancel, msgCancel, healthCheckSrv, metricsSrv, adminSrv)
 			return
 		}
 	}
@@ -63,6 +64,7 @@ func (a *Archiver) shutdown(
 	msgCancel context.CancelFunc,
 	healthCheckSrv *http.Server,
 	metricsSrv *http.Server,
+	adminSrv *http.Server,
 ) {
 	log.Info().Msgf("Received %s signal, starting %s shutdown wait", sig, shutdownWaitDuration)
 
@@ -77,6 +79,8 @@ func (a *Archiver) shutdown(
 	httpServerShutdown(healthCheckSrv)
 	// stop metrics server
 	httpServerShutdown(metricsSrv)
+	// stop the delete breaker admin api server
+	httpServerShutdown(adminSrv)
 	// wait for everything to finish
 	a.WaitGroup.Wait()
 }
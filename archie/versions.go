@@ -0,0 +1,50 @@
+package archie
+
+import (
+	"archie/client"
+	"context"
+	"github.com/rs/zerolog"
+)
+
+// permanently delete the destination versions that were copied from the source version,
+// they're found by the source version id archie recorded on the copy
+func (a *Archiver) removeVersion(ctx context.Context, mLog zerolog.Logger, dest *Destination, key string, srcVersionID string) error {
+	// canceling the listing stops its goroutine on an early return
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	removed := 0
+	for info := range dest.Client.ListObjects(listCtx, dest.Bucket, key, client.ListOptions{Versions: true}) {
+		if info.Err != nil {
+			return info.Err
+		}
+		// the prefix also lists the longer keys
+		if info.Key != key || info.IsDeleteMarker {
+			continue
+		}
+
+		// listings don't include the user metadata on every backend
+		destVersion, err := dest.Client.StatObject(ctx, dest.Bucket, key, client.GetOptions{VersionID: info.VersionID})
+		if err != nil {
+			return err
+		}
+		if client.MetadataValue(destVersion.UserMetadata, client.SourceVersionMetadata) != srcVersionID {
+			continue
+		}
+
+		err = dest.Client.RemoveObject(ctx, dest.Bucket, key, client.RemoveOptions{VersionID: info.VersionID})
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+
+	if removed == 0 {
+		mLog.Info().
+			Str("destination", dest.Name).
+			Str("versionId", srcVersionID).
+			Msg("No destination version was copied from the source version, delete skipped")
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archie/archie"
+	"archie/client"
+	"context"
+	"flag"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// backfill syncs the objects that already exist in the source bucket,
+// the ones that never had an event or were missed
+func backfill(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "config file path")
+	logLevelFlag := flags.String("log-level", LookupEnvOrString("LOG_LEVEL", ""), "set the log level (default: info)")
+	prefix := flags.String("prefix", "", "only backfill the keys with this prefix")
+	mode := flags.String("mode", "enqueue", "\"enqueue\" copy events to jetstream.subject or \"copy\" the objects directly")
+	dryRun := flags.Bool("dry-run", false, "only report what would be enqueued or copied")
+	restart := flags.Bool("restart", false, "ignore the checkpoint and start from the beginning")
+	workers := flags.Int("workers", 0, "number of objects to copy concurrently (default: workers)")
+	_ = flags.Parse(args)
+
+	cfg := loadConfig(*configFile)
+	setLogLevel(*logLevelFlag, cfg.LogLevel)
+
+	if *mode != "enqueue" && *mode != "copy" {
+		log.Fatal().Msgf("Unknown backfill mode %s, must be one of: enqueue, copy", *mode)
+	}
+
+	// stop cleanly on ctrl-c, the checkpoint lets the next run resume
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logConfig(cfg)
+
+	a := newArchiver(cfg)
+	a.DryRun = *dryRun
+	if *workers > 0 {
+		a.Workers = *workers
+	}
+
+	healthCheckCancel := setupClients(ctx, cfg, a)
+	defer healthCheckCancel()
+
+	natsClient := client.Connect(cfg.Jetstream.URL, cfg.Jetstream.RootCA, cfg.Jetstream.Username, cfg.Jetstream.Password)
+	defer natsClient.Close()
+
+	jetStream, err := natsClient.JetStream()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize JetStream context")
+	}
+	a.JetStream = jetStream
+
+	// the copies index their date partitioned keys
+	setupKeyIndex(cfg, natsClient, a)
+
+	backfillOpts := archie.BackfillOptions{
+		CheckpointKV: client.KeyValue(
+			natsClient,
+			cfg.Jetstream.CheckpointBucket,
+			"",
+			cfg.Jetstream.Stream.Replicas,
+			cfg.Jetstream.ProvisioningDisabled,
+		),
+		Prefix:  *prefix,
+		Restart: *restart,
+	}
+	if *mode == "enqueue" {
+		backfillOpts.Subject = cfg.Jetstream.Subject
+	}
+
+	log.Info().
+		Str("bucket", cfg.Src.Bucket).
+		Str("prefix", *prefix).
+		Str("mode", *mode).
+		Bool("dryRun", *dryRun).
+		Msg("Backfill started")
+
+	result, err := a.Backfill(ctx, backfillOpts)
+
+	// the copies are recorded even when the backfill stopped
+	if flushErr := a.FlushManifest(context.Background()); flushErr != nil {
+		log.Error().Err(flushErr).Msg("Failed to flush the manifest")
+	}
+
+	if err != nil {
+		log.Error().Err(err).Interface("result", result).Msg("Backfill stopped")
+		return 1
+	}
+
+	log.Info().Interface("result", result).Msg("Backfill complete")
+	return 0
+}
@@ -0,0 +1,453 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Azure struct {
+	client   *service.Client
+	endpoint string
+	offline  atomic.Bool
+}
+
+type AzureObject struct {
+	Bucket    string
+	Path      string
+	Reader    io.Reader
+	Client    *service.Client
+	VersionID string
+}
+
+func (az *Azure) New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc {
+	var client *azblob.Client
+	var err error
+
+	if creds.AzureConnectionString != "" {
+		client, err = azblob.NewClientFromConnectionString(creds.AzureConnectionString, nil)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Failed to setup %s client from the connection string", name)
+		}
+	} else {
+		// azurite uses http://127.0.0.1:10000/devstoreaccount1
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("%s.blob.core.windows.net", creds.AzureAccountName)
+			useSSL = true
+		}
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			if useSSL {
+				endpoint = "https://" + endpoint
+			} else {
+				endpoint = "http://" + endpoint
+			}
+		}
+
+		sharedKey, err := azblob.NewSharedKeyCredential(creds.AzureAccountName, creds.AzureAccountKey)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Failed to setup %s client shared key", name)
+		}
+
+		client, err = azblob.NewClientWithSharedKeyCredential(endpoint, sharedKey, nil)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Failed to setup %s client", name)
+		}
+	}
+
+	az.client = client.ServiceClient()
+	az.endpoint = client.URL()
+
+	if p.Threads == 0 && p.PartSize == 0 {
+		log.Info().Msgf("Setup %s client to %s", name, az.endpoint)
+	} else {
+		log.Info().Msgf("Setup %s client to %s with %d threads and %dMB part size", name, az.endpoint, p.Threads, p.PartSize)
+	}
+
+	_, err = az.client.NewContainerClient(bucket).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			log.Fatal().Msgf("%s bucket %s does not exist or access is missing", name, bucket)
+		} else {
+			log.Fatal().Err(err).Msgf("Failed to check if %s bucket %s exists", name, bucket)
+		}
+	}
+
+	// the azure client doesn't offer a health-check so probe the container
+	healthCheckCtx, healthCheckCancel := context.WithCancel(ctx)
+	go az.healthCheck(healthCheckCtx, bucket, 5*time.Second)
+
+	return healthCheckCancel
+}
+
+func (az *Azure) healthCheck(ctx context.Context, bucket string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			probeCtx, probeCancel := context.WithTimeout(ctx, interval)
+			_, err := az.client.NewContainerClient(bucket).GetProperties(probeCtx, nil)
+			probeCancel()
+			// only a missing answer counts as offline, not an error response
+			var respErr *azcore.ResponseError
+			az.offline.Store(err != nil && !errors.As(err, &respErr))
+		}
+	}
+}
+
+func (az *Azure) GetObject(ctx context.Context, bucket string, key string, opts GetOptions) (Object, error) {
+	blobClient, err := azureBlob(az.client, bucket, key, opts.VersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	var mo Object = &AzureObject{Bucket: bucket, Path: key, Reader: resp.NewRetryReader(ctx, nil), Client: az.client, VersionID: opts.VersionID}
+	return mo, nil
+}
+
+func (az *Azure) PutObject(ctx context.Context, bucket string, key string, reader io.Reader, objectSize int64, opts PutOptions) (UploadInfo, error) {
+	// upload stream stages each block then commits the block list
+	uploadOpts := &blockblob.UploadStreamOptions{
+		BlockSize:   int64(opts.PartSize),
+		Concurrency: int(opts.NumThreads),
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobCacheControl:       azureString(opts.CacheControl),
+			BlobContentDisposition: azureString(opts.ContentDisposition),
+			BlobContentEncoding:    azureString(opts.ContentEncoding),
+			BlobContentLanguage:    azureString(opts.ContentLanguage),
+			BlobContentType:        &opts.ContentType,
+		},
+		Metadata: map[string]*string{},
+		Tags:     opts.Tags,
+	}
+
+	if opts.StorageClass != "" {
+		accessTier := blob.AccessTier(opts.StorageClass)
+		uploadOpts.AccessTier = &accessTier
+	}
+
+	for name, value := range putUserMetadata(opts) {
+		value := value
+		uploadOpts.Metadata[azureMetadataName(name)] = &value
+	}
+
+	_, err := az.client.NewContainerClient(bucket).NewBlockBlobClient(key).UploadStream(ctx, reader, uploadOpts)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	return UploadInfo{}, nil
+}
+
+func (az *Azure) CopyObject(ctx context.Context, srcBucket string, srcKey string, bucket string, key string, opts PutOptions) (UploadInfo, error) {
+	return UploadInfo{}, ErrCopyNotSupported
+}
+
+func (az *Azure) UpdateMetadata(ctx context.Context, bucket string, key string, metadata map[string]string) error {
+	blobClient := az.client.NewContainerClient(bucket).NewBlockBlobClient(key)
+
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return azureError(err)
+	}
+
+	// setting the metadata replaces all of it so the current names are merged in
+	merged := props.Metadata
+	if merged == nil {
+		merged = map[string]*string{}
+	}
+	for name, value := range metadata {
+		value := value
+		// response names come back canonicalized and older objects have the dashes dropped,
+		// drop them so the name isn't sent twice
+		for existing := range merged {
+			if sameMetadataName(azureUserMetadataName(existing), name) {
+				delete(merged, existing)
+			}
+		}
+		merged[azureMetadataName(name)] = &value
+	}
+
+	_, err = blobClient.SetMetadata(ctx, merged, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: props.ETag},
+		},
+	})
+	if err != nil {
+		return azureError(err)
+	}
+	return nil
+}
+
+func (az *Azure) RemoveObject(ctx context.Context, bucket string, key string, opts RemoveOptions) error {
+	blobClient, err := azureBlob(az.client, bucket, key, opts.VersionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = blobClient.Delete(ctx, nil)
+	if err != nil {
+		return azureError(err)
+	}
+	return nil
+}
+
+func (az *Azure) ListObjects(ctx context.Context, bucket string, prefix string, opts ListOptions) <-chan ObjectInfo {
+	objectCh := make(chan ObjectInfo)
+
+	go func() {
+		defer close(objectCh)
+
+		// the listing can't start after a key, it resumes from the marker of the page that has it
+		// and the keys up to the start are skipped
+		listOpts := &container.ListBlobsFlatOptions{
+			Include: container.ListBlobsInclude{Metadata: true, Versions: opts.Versions},
+			Prefix:  &prefix,
+		}
+		if opts.Marker != "" {
+			listOpts.Marker = &opts.Marker
+		}
+		pager := az.client.NewContainerClient(bucket).NewListBlobsFlatPager(listOpts)
+
+		marker := opts.Marker
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				select {
+				case objectCh <- ObjectInfo{Err: azureError(err)}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			for _, item := range page.Segment.BlobItems {
+				if item.Name == nil || *item.Name <= opts.StartAfter {
+					continue
+				}
+
+				info := ObjectInfo{Key: *item.Name, Marker: marker, UserMetadata: azureUserMetadata(item.Metadata)}
+				if item.Properties != nil {
+					if item.Properties.ContentLength != nil {
+						info.Size = *item.Properties.ContentLength
+					}
+					if item.Properties.ContentType != nil {
+						info.ContentType = *item.Properties.ContentType
+					}
+					if item.Properties.LastModified != nil {
+						info.LastModified = *item.Properties.LastModified
+					}
+					info.ETag = azureETag(info.UserMetadata, item.Properties.ETag)
+				}
+				if item.VersionID != nil {
+					info.VersionID = *item.VersionID
+				}
+				if item.IsCurrentVersion != nil {
+					info.IsLatest = *item.IsCurrentVersion
+				}
+
+				select {
+				case objectCh <- info:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if page.NextMarker != nil {
+				marker = *page.NextMarker
+			}
+		}
+	}()
+
+	return objectCh
+}
+
+func (az *Azure) IsOffline() bool {
+	return az.offline.Load()
+}
+
+func (az *Azure) EndpointURL() string {
+	return az.endpoint
+}
+
+func (az *Azure) StatObject(ctx context.Context, bucket string, key string, opts GetOptions) (*ObjectInfo, error) {
+	return azureStat(ctx, az.client, bucket, key, opts.VersionID)
+}
+
+func (o *AzureObject) Stat(ctx context.Context) (*ObjectInfo, error) {
+	return azureStat(ctx, o.Client, o.Bucket, o.Path, o.VersionID)
+}
+
+func (o *AzureObject) GetReader() io.Reader {
+	return o.Reader
+}
+
+func azureStat(ctx context.Context, client *service.Client, bucket string, key string, versionID string) (*ObjectInfo, error) {
+	blobClient, err := azureBlob(client, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, azureError(err)
+	}
+
+	info := &ObjectInfo{
+		CacheControl:       azureValue(props.CacheControl),
+		ContentDisposition: azureValue(props.ContentDisposition),
+		ContentEncoding:    azureValue(props.ContentEncoding),
+		ContentLanguage:    azureValue(props.ContentLanguage),
+		ContentType:        azureValue(props.ContentType),
+		Key:                key,
+		StorageClass:       azureValue(props.AccessTier),
+		UserMetadata:       azureUserMetadata(props.Metadata),
+	}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.LastModified = *props.LastModified
+	}
+	if props.VersionID != nil {
+		info.VersionID = *props.VersionID
+	}
+	info.ETag = azureETag(info.UserMetadata, props.ETag)
+
+	// the properties only count the index tags
+	if props.TagCount != nil && *props.TagCount > 0 {
+		tagsResp, err := blobClient.GetTags(ctx, nil)
+		if err != nil {
+			return nil, azureError(err)
+		}
+
+		info.Tags = map[string]string{}
+		for _, tag := range tagsResp.BlobTagSet {
+			if tag.Key != nil && tag.Value != nil {
+				info.Tags[*tag.Key] = *tag.Value
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func azureUserMetadata(metadata map[string]*string) map[string]string {
+	userMetadata := map[string]string{}
+	for name, value := range metadata {
+		if value != nil {
+			userMetadata[azureUserMetadataName(name)] = *value
+		}
+	}
+	return userMetadata
+}
+
+// prefer the source ETag archie stored over the blob's own ETag
+func azureETag(userMetadata map[string]string, eTag *azcore.ETag) string {
+	if value := MetadataValue(userMetadata, ETagMetadata); value != "" {
+		return value
+	}
+	if eTag != nil {
+		return strings.Trim(string(*eTag), "\"")
+	}
+	return ""
+}
+
+// the blob client for a version, the current one without a version id
+func azureBlob(client *service.Client, bucket string, key string, versionID string) (*blockblob.Client, error) {
+	blobClient := client.NewContainerClient(bucket).NewBlockBlobClient(key)
+	if versionID == "" {
+		return blobClient, nil
+	}
+	return blobClient.WithVersionID(versionID)
+}
+
+// empty headers are left unset
+func azureString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func azureValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// azure metadata names must be valid c# identifiers, the other characters are escaped as _ and their hex
+// bytes and the underscore as __ so the names read back as they were written
+func azureMetadataName(name string) string {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			builder.WriteString("__")
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9' && i > 0:
+			builder.WriteByte(c)
+		default:
+			builder.WriteString(fmt.Sprintf("_%02X", c))
+		}
+	}
+	return builder.String()
+}
+
+// the user metadata name of an azure metadata name, the names older versions wrote without their dashes stay as they are
+func azureUserMetadataName(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '_' {
+			builder.WriteByte(name[i])
+			continue
+		}
+		if i+1 < len(name) && name[i+1] == '_' {
+			builder.WriteByte('_')
+			i++
+			continue
+		}
+		// the responses canonicalize the names so the hex can be lowercase
+		if i+2 < len(name) {
+			if escaped, err := hex.DecodeString(name[i+1 : i+3]); err == nil {
+				builder.Write(escaped)
+				i += 2
+				continue
+			}
+		}
+		builder.WriteByte(name[i])
+	}
+	return builder.String()
+}
+
+// normalize the missing blob error so it can be matched like the other clients
+func azureError(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return errors.New("The specified blob does not exist.")
+	}
+	return err
+}
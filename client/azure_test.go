@@ -0,0 +1,53 @@
+package client
+
+import "testing"
+
+func TestAzureMetadataName(t *testing.T) {
+	tests := []struct {
+		name  string
+		azure string
+	}{
+		{"Archie-Checksum", "Archie_2DChecksum"},
+		{"plain", "plain"},
+		{"snake_case", "snake__case"},
+		{"dotted.name", "dotted_2Ename"},
+		{"1leading-digit", "_31leading_2Ddigit"},
+		{"_leading", "__leading"},
+		{"space and ü", "space_20and_20_C3_BC"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			azure := azureMetadataName(test.name)
+			if azure != test.azure {
+				t.Errorf("azureMetadataName(%q) = %q, want %q", test.name, azure, test.azure)
+			}
+			if name := azureUserMetadataName(azure); name != test.name {
+				t.Errorf("azureUserMetadataName(%q) = %q, want %q", azure, name, test.name)
+			}
+		})
+	}
+}
+
+func TestAzureUserMetadataName(t *testing.T) {
+	tests := []struct {
+		azure string
+		name  string
+	}{
+		// the responses canonicalize the names
+		{"Archie_2dchecksum", "Archie-checksum"},
+		// older versions dropped the dashes
+		{"ArchieChecksum", "ArchieChecksum"},
+		{"trailing_", "trailing_"},
+		{"not_hex", "not_hex"},
+	}
+
+	for _, test := range tests {
+		if name := azureUserMetadataName(test.azure); name != test.name {
+			t.Errorf("azureUserMetadataName(%q) = %q, want %q", test.azure, name, test.name)
+		}
+	}
+	if value := MetadataValue(azureUserMetadata(map[string]*string{"Archie_2dchecksum": azureString("sha256:00")}), ChecksumMetadata); value != "sha256:00" {
+		t.Errorf("MetadataValue of the escaped checksum = %q", value)
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChecksumCRC32C = "crc32c"
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+)
+
+// ChecksumMetadata is the user metadata name archie records the verified checksum under as algorithm:hex
+const ChecksumMetadata = "Archie-Checksum"
+
+// NewChecksumHash returns the hash for a checksum algorithm
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %s, must be one of: md5, crc32c, sha256", algorithm)
+}
+
+// the checksum put with the object under ChecksumMetadata, it's known before the upload so a backend
+// that takes it with the upload rejects a corrupted one
+func putChecksum(opts PutOptions) (string, []byte) {
+	algorithm, sum, ok := strings.Cut(MetadataValue(opts.UserMetadata, ChecksumMetadata), ":")
+	if !ok {
+		return "", nil
+	}
+
+	decoded, err := hex.DecodeString(sum)
+	if err != nil {
+		return "", nil
+	}
+	return algorithm, decoded
+}
+
+// multipartMD5 hashes a stream like the etag of its multipart upload, the md5 of the part md5s and the
+// part count, along with the md5 of the whole stream
+type multipartMD5 struct {
+	content  hash.Hash
+	part     hash.Hash
+	partSize int64
+	parts    int
+	sums     []byte
+	written  int64
+}
+
+func newMultipartMD5(partSize int64) *multipartMD5 {
+	return &multipartMD5{content: md5.New(), partSize: partSize}
+}
+
+func (m *multipartMD5) Write(p []byte) (int, error) {
+	m.content.Write(p)
+
+	n := len(p)
+	for len(p) > 0 {
+		if m.part == nil {
+			m.part = md5.New()
+		}
+		chunk := p
+		if remaining := m.partSize - m.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		m.part.Write(chunk)
+		m.written += int64(len(chunk))
+		p = p[len(chunk):]
+
+		if m.written == m.partSize {
+			m.finishPart()
+		}
+	}
+	return n, nil
+}
+
+func (m *multipartMD5) finishPart() {
+	m.sums = m.part.Sum(m.sums)
+	m.parts++
+	m.part = nil
+	m.written = 0
+}
+
+// the checksum of the stream when the multipart etag matches its parts, the etag when it doesn't
+// so the mismatch is reported, empty when the upload was split into other parts
+func (m *multipartMD5) checksum(eTag string) string {
+	if m.part != nil {
+		m.finishPart()
+	}
+
+	_, count, _ := strings.Cut(eTag, "-")
+	if count != strconv.Itoa(m.parts) {
+		return ""
+	}
+
+	sum := md5.Sum(m.sums)
+	if !strings.EqualFold(eTag, fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), m.parts)) {
+		return strings.ToLower(eTag)
+	}
+	return hex.EncodeToString(m.content.Sum(nil))
+}
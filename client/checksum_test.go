@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// the etag s3 gives a multipart upload of the parts
+func multipartETag(parts ...[]byte) string {
+	var sums []byte
+	for _, part := range parts {
+		sum := md5.Sum(part)
+		sums = append(sums, sum[:]...)
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(parts))
+}
+
+func TestMultipartMD5(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	contentSum := md5.Sum(content)
+	contentMD5 := hex.EncodeToString(contentSum[:])
+
+	tests := []struct {
+		name string
+		eTag string
+		want string
+	}{
+		{"matching parts", multipartETag(content[:100], content[100:200], content[200:]), contentMD5},
+		{"other part count", multipartETag(content[:200], content[200:]), ""},
+		{"corrupted part", multipartETag(content[:100], content[100:199], content[200:]), multipartETag(content[:100], content[100:199], content[200:])},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := newMultipartMD5(100)
+			// the writes don't line up with the parts
+			for i := 0; i < len(content); i += 33 {
+				end := i + 33
+				if end > len(content) {
+					end = len(content)
+				}
+				if _, err := parts.Write(content[i:end]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := parts.checksum(test.eTag); got != test.want {
+				t.Errorf("checksum(%q) = %q, want %q", test.eTag, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultipartMD5ExactParts(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 200)
+	parts := newMultipartMD5(100)
+	if _, err := parts.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stream ending on a part boundary has no empty last part
+	sum := md5.Sum(content)
+	if got := parts.checksum(multipartETag(content[:100], content[100:])); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum = %q, want the content md5", got)
+	}
+}
+
+func TestPutChecksum(t *testing.T) {
+	tests := []struct {
+		metadata  map[string]string
+		algorithm string
+		sum       string
+	}{
+		{map[string]string{ChecksumMetadata: "md5:9e107d9d372bb6826bd81d3542a419d6"}, ChecksumMD5, "9e107d9d372bb6826bd81d3542a419d6"},
+		// the gcs spelling of the name
+		{map[string]string{"archie-checksum": "crc32c:e3069283"}, ChecksumCRC32C, "e3069283"},
+		{map[string]string{ChecksumMetadata: "md5:not hex"}, "", ""},
+		{map[string]string{ChecksumMetadata: "9e107d9d372bb6826bd81d3542a419d6"}, "", ""},
+		{nil, "", ""},
+	}
+
+	for _, test := range tests {
+		algorithm, sum := putChecksum(PutOptions{UserMetadata: test.metadata})
+		if algorithm != test.algorithm || hex.EncodeToString(sum) != test.sum {
+			t.Errorf("putChecksum(%v) = %s:%x, want %s:%s", test.metadata, algorithm, sum, test.algorithm, test.sum)
+		}
+	}
+}
@@ -2,17 +2,59 @@ package client
 
 import (
 	"context"
+	"errors"
 	"github.com/rs/zerolog"
 	"io"
+	"reflect"
+	"time"
 )
 
 type Client interface {
+	CopyObject(ctx context.Context, srcBucket string, srcKey string, bucket string, key string, opts PutOptions) (UploadInfo, error)
 	EndpointURL() string
-	GetObject(ctx context.Context, bucket string, key string) (Object, error)
+	GetObject(ctx context.Context, bucket string, key string, opts GetOptions) (Object, error)
 	IsOffline() bool
+	ListObjects(ctx context.Context, bucket string, prefix string, opts ListOptions) <-chan ObjectInfo
 	New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc
 	PutObject(ctx context.Context, bucket string, key string, reader io.Reader, objectSize int64, opts PutOptions) (UploadInfo, error)
-	RemoveObject(ctx context.Context, bucket string, key string) error
+	RemoveObject(ctx context.Context, bucket string, key string, opts RemoveOptions) error
+	StatObject(ctx context.Context, bucket string, key string, opts GetOptions) (*ObjectInfo, error)
+	UpdateMetadata(ctx context.Context, bucket string, key string, metadata map[string]string) error
+}
+
+// ErrCopyNotSupported is returned by the clients that can't copy objects server-side
+var ErrCopyNotSupported = errors.New("server-side copy is not supported")
+
+// ErrRetentionNotSupported is returned by the clients that can't lock an object until a date
+var ErrRetentionNotSupported = errors.New("object retention is not supported")
+
+// CanCopy reports if the dest client can copy the src client's objects server-side,
+// both need the same backend and endpoint
+func CanCopy(src Client, dest Client) bool {
+	switch dest.(type) {
+	case *Minio, *S3, *GCS:
+	default:
+		return false
+	}
+	return reflect.TypeOf(src) == reflect.TypeOf(dest) && src.EndpointURL() == dest.EndpointURL()
+}
+
+// CanRetain reports if the client can put an object under a retention mode, the bucket needs object lock
+func CanRetain(c Client) bool {
+	switch c.(type) {
+	case *Minio, *S3:
+		return true
+	}
+	return false
+}
+
+// CanLegalHold reports if the client can put an object under a legal hold, a temporary hold on gcs
+func CanLegalHold(c Client) bool {
+	switch c.(type) {
+	case *Minio, *S3, *GCS:
+		return true
+	}
+	return false
 }
 
 type Object interface {
@@ -21,27 +63,94 @@ type Object interface {
 }
 
 type PutOptions struct {
-	ContentType string
-	ETag        string
-	NumThreads  uint
-	PartSize    uint64
+	CacheControl string
+	// Checksum is the algorithm the destination should report in the UploadInfo when it can
+	Checksum           string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+	ETag               string
+	// LegalHold keeps the object from being deleted until the hold is removed
+	LegalHold  bool
+	NumThreads uint
+	PartSize   uint64
+	// RetainUntil with a RetentionMode, GOVERNANCE or COMPLIANCE, locks the object version until then
+	RetainUntil   time.Time
+	RetentionMode string
+	// SourceVersionID is recorded with the object, a server-side copy reads that version
+	SourceVersionID string
+	// StorageClass is the backend's own class name, an azure access tier, or the bucket default when empty
+	StorageClass string
+	Tags         map[string]string
+	UserMetadata map[string]string
 }
 
+// GetOptions select an object version, the latest without one
+type GetOptions struct {
+	VersionID string
+}
+
+// RemoveOptions select an object version to delete permanently, without one a versioned bucket
+// gets a delete marker
+type RemoveOptions struct {
+	VersionID string
+}
+
+// ObjectInfo's ETag is the source ETag archie stored with the object when there is one,
+// IsDeleteMarker and IsLatest are only meaningful in a versioned listing, Marker resumes a listing
+// from the object's page on the clients that can't start after a key
 type ObjectInfo struct {
-	ContentType string
-	ETag        string
-	Size        int64
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+	ETag               string
+	Err                error
+	IsDeleteMarker     bool
+	IsLatest           bool
+	Key                string
+	LastModified       time.Time
+	Marker             string
+	Size               int64
+	StorageClass       string
+	Tags               map[string]string
+	UserMetadata       map[string]string
+	VersionID          string
 }
 
-type UploadInfo struct{}
+// ListOptions for listing the objects of a bucket in lexical key order,
+// with Versions every version and delete marker of a key is listed
+type ListOptions struct {
+	// Marker is the ObjectInfo Marker of the StartAfter key, it saves listing the keys before it again
+	Marker     string
+	StartAfter string
+	Versions   bool
+}
+
+// UploadInfo's Checksum is the hex checksum the destination reported for the requested algorithm,
+// empty when the destination can't report it
+type UploadInfo struct {
+	Checksum string
+}
 
 type Credentials struct {
-	MinioAccessKey       string
-	MinioSecretAccessKey string
-	GoogleCredentials    string
+	MinioAccessKey        string
+	MinioSecretAccessKey  string
+	GoogleCredentials     string
+	AWSSessionToken       string
+	AWSRoleARN            string
+	AWSRoleSessionName    string
+	AWSSTSEndpoint        string
+	AzureAccountKey       string
+	AzureAccountName      string
+	AzureConnectionString string
 }
 
 type Params struct {
-	PartSize uint64
-	Threads  uint
+	BucketLookup string
+	PartSize     uint64
+	Region       string
+	Threads      uint
 }
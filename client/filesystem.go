@@ -0,0 +1,536 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// directory inside each bucket directory that holds the json sidecar metadata files
+const filesystemMetaDir = ".archie-meta"
+
+var errFilesystemVersions = errors.New("the filesystem client has no object versions")
+
+// Filesystem maps a bucket to a directory under the endpoint root directory and keys to files in it
+type Filesystem struct {
+	root    string
+	offline atomic.Bool
+}
+
+type FilesystemObject struct {
+	Bucket string
+	Path   string
+	Reader io.Reader
+	fs     *Filesystem
+}
+
+// filesystemMeta is the json sidecar stored for each file
+type filesystemMeta struct {
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	ContentType        string            `json:"contentType"`
+	ETag               string            `json:"etag"`
+	MD5                string            `json:"md5"`
+	Size               int64             `json:"size"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	UserMetadata       map[string]string `json:"userMetadata,omitempty"`
+}
+
+func (f *Filesystem) New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc {
+	root, err := filepath.Abs(endpoint)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to setup %s client", name)
+	}
+	f.root = root
+
+	if p.Threads == 0 && p.PartSize == 0 {
+		log.Info().Msgf("Setup %s client to %s", name, f.root)
+	} else {
+		log.Info().Msgf("Setup %s client to %s with %d threads and %dMB part size", name, f.root, p.Threads, p.PartSize)
+	}
+
+	bucketInfo, err := os.Stat(filepath.Join(f.root, bucket))
+	if err != nil || !bucketInfo.IsDir() {
+		log.Fatal().Msgf("%s bucket %s does not exist or access is missing", name, bucket)
+	}
+
+	// probe the mount, a hung nfs mount blocks instead of erroring
+	healthCheckCtx, healthCheckCancel := context.WithCancel(ctx)
+	go f.healthCheck(healthCheckCtx, 5*time.Second)
+
+	return healthCheckCancel
+}
+
+func (f *Filesystem) healthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			probe := make(chan bool, 1)
+			go func() {
+				info, err := os.Stat(f.root)
+				probe <- err == nil && info.IsDir()
+			}()
+
+			select {
+			case online := <-probe:
+				f.offline.Store(!online)
+			case <-time.After(interval):
+				f.offline.Store(true)
+			}
+		}
+	}
+}
+
+// resolve a bucket and key to a path, without escaping the bucket directory
+func (f *Filesystem) path(bucket string, key string) (string, error) {
+	bucketPath := filepath.Join(f.root, bucket)
+	objectPath := filepath.Join(bucketPath, filepath.FromSlash(key))
+
+	if !strings.HasPrefix(objectPath, bucketPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %s resolves outside of the bucket directory", key)
+	}
+	if strings.HasPrefix(objectPath, filepath.Join(bucketPath, filesystemMetaDir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %s is inside the reserved %s directory", key, filesystemMetaDir)
+	}
+	return objectPath, nil
+}
+
+func (f *Filesystem) metaPath(bucket string, key string) string {
+	return filepath.Join(f.root, bucket, filesystemMetaDir, filepath.FromSlash(key)+".json")
+}
+
+func (f *Filesystem) GetObject(ctx context.Context, bucket string, key string, opts GetOptions) (Object, error) {
+	if opts.VersionID != "" {
+		return nil, errFilesystemVersions
+	}
+
+	objectPath, err := f.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var mo Object = &FilesystemObject{Bucket: bucket, Path: key, Reader: &closingReader{file: file}, fs: f}
+	return mo, nil
+}
+
+func (f *Filesystem) PutObject(ctx context.Context, bucket string, key string, reader io.Reader, objectSize int64, opts PutOptions) (UploadInfo, error) {
+	objectPath, err := f.path(bucket, key)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	md5Hash := md5.New()
+	writers := []io.Writer{md5Hash}
+
+	var checksumHash hash.Hash
+	if opts.Checksum != "" && opts.Checksum != ChecksumMD5 {
+		checksumHash, err = NewChecksumHash(opts.Checksum)
+		if err != nil {
+			return UploadInfo{}, err
+		}
+		writers = append(writers, checksumHash)
+	}
+
+	written, err := writeFileAtomic(objectPath, io.TeeReader(contextReader(ctx, reader), io.MultiWriter(writers...)), objectSize)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	meta := filesystemMeta{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        opts.ContentType,
+		ETag:               opts.ETag,
+		MD5:                hex.EncodeToString(md5Hash.Sum(nil)),
+		Size:               written,
+		Tags:               opts.Tags,
+	}
+
+	userMetadata := putUserMetadata(opts)
+	if len(userMetadata) > 0 {
+		meta.UserMetadata = userMetadata
+	}
+
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	_, err = writeFileAtomic(f.metaPath(bucket, key), strings.NewReader(string(metaJSON)), -1)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	info := UploadInfo{}
+	if opts.Checksum == ChecksumMD5 {
+		info.Checksum = meta.MD5
+	} else if checksumHash != nil {
+		info.Checksum = hex.EncodeToString(checksumHash.Sum(nil))
+	}
+	return info, nil
+}
+
+func (f *Filesystem) CopyObject(ctx context.Context, srcBucket string, srcKey string, bucket string, key string, opts PutOptions) (UploadInfo, error) {
+	return UploadInfo{}, ErrCopyNotSupported
+}
+
+func (f *Filesystem) UpdateMetadata(ctx context.Context, bucket string, key string, metadata map[string]string) error {
+	objectPath, err := f.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	meta := filesystemMeta{}
+
+	metaJSON, err := os.ReadFile(f.metaPath(bucket, key))
+	if err == nil {
+		err = json.Unmarshal(metaJSON, &meta)
+		if err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) {
+		// files written outside archie get a sidecar
+		fileInfo, err := os.Stat(objectPath)
+		if err != nil {
+			return err
+		}
+		meta.Size = fileInfo.Size()
+	} else {
+		return err
+	}
+
+	if meta.UserMetadata == nil {
+		meta.UserMetadata = map[string]string{}
+	}
+	for name, value := range metadata {
+		meta.UserMetadata[name] = value
+	}
+
+	metaJSON, err = json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(f.metaPath(bucket, key), strings.NewReader(string(metaJSON)), -1)
+	return err
+}
+
+func (f *Filesystem) RemoveObject(ctx context.Context, bucket string, key string, opts RemoveOptions) error {
+	if opts.VersionID != "" {
+		return errFilesystemVersions
+	}
+
+	objectPath, err := f.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(objectPath)
+	if err != nil {
+		return err
+	}
+
+	metaPath := f.metaPath(bucket, key)
+	err = os.Remove(metaPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// clean up the directories that only existed for this key
+	removeEmptyDirs(filepath.Dir(objectPath), filepath.Join(f.root, bucket))
+	removeEmptyDirs(filepath.Dir(metaPath), filepath.Join(f.root, bucket, filesystemMetaDir))
+
+	return nil
+}
+
+func (f *Filesystem) ListObjects(ctx context.Context, bucket string, prefix string, opts ListOptions) <-chan ObjectInfo {
+	objectCh := make(chan ObjectInfo)
+
+	go func() {
+		defer close(objectCh)
+		f.walk(ctx, bucket, filepath.Join(f.root, bucket), "", prefix, opts.StartAfter, objectCh)
+	}()
+
+	return objectCh
+}
+
+// walk a directory in lexical key order, a directory sorts like its key with a trailing slash
+func (f *Filesystem) walk(ctx context.Context, bucket, dir, dirKey, prefix, startAfter string, objectCh chan<- ObjectInfo) bool {
+	send := func(info ObjectInfo) bool {
+		select {
+		case objectCh <- info:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		send(ObjectInfo{Err: err})
+		return false
+	}
+
+	type walkEntry struct {
+		isDir bool
+		key   string
+		path  string
+	}
+
+	walkEntries := make([]walkEntry, 0, len(entries))
+	for _, entry := range entries {
+		key := dirKey + entry.Name()
+		if entry.IsDir() {
+			if dirKey == "" && entry.Name() == filesystemMetaDir {
+				continue
+			}
+			key += "/"
+		} else if strings.HasPrefix(entry.Name(), ".archie-tmp-") {
+			continue
+		}
+		walkEntries = append(walkEntries, walkEntry{isDir: entry.IsDir(), key: key, path: filepath.Join(dir, entry.Name())})
+	}
+
+	sort.Slice(walkEntries, func(i, j int) bool {
+		return walkEntries[i].key < walkEntries[j].key
+	})
+
+	for _, entry := range walkEntries {
+		if entry.isDir {
+			// only descend when the directory can hold keys with the prefix past the start
+			if !strings.HasPrefix(entry.key, prefix) && !strings.HasPrefix(prefix, entry.key) {
+				continue
+			}
+			if entry.key < startAfter && !strings.HasPrefix(startAfter, entry.key) {
+				continue
+			}
+			if !f.walk(ctx, bucket, entry.path, entry.key, prefix, startAfter, objectCh) {
+				return false
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(entry.key, prefix) || entry.key <= startAfter {
+			continue
+		}
+
+		info, err := f.stat(bucket, entry.key)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed since the directory was read
+				continue
+			}
+			info = &ObjectInfo{Err: err}
+		}
+		info.Key = entry.key
+		// a file is its only version
+		info.IsLatest = true
+
+		if !send(*info) || info.Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Filesystem) StatObject(ctx context.Context, bucket string, key string, opts GetOptions) (*ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, errFilesystemVersions
+	}
+	return f.stat(bucket, key)
+}
+
+func (f *Filesystem) IsOffline() bool {
+	return f.offline.Load()
+}
+
+func (f *Filesystem) EndpointURL() string {
+	return "file://" + filepath.ToSlash(f.root)
+}
+
+func (o *FilesystemObject) Stat(ctx context.Context) (*ObjectInfo, error) {
+	return o.fs.stat(o.Bucket, o.Path)
+}
+
+func (o *FilesystemObject) GetReader() io.Reader {
+	return o.Reader
+}
+
+func (f *Filesystem) stat(bucket string, key string) (*ObjectInfo, error) {
+	objectPath, err := f.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ObjectInfo{Key: key, LastModified: fileInfo.ModTime(), Size: fileInfo.Size(), UserMetadata: map[string]string{}}
+
+	metaJSON, err := os.ReadFile(f.metaPath(bucket, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// files written outside archie have no sidecar
+			return info, nil
+		}
+		return nil, err
+	}
+
+	meta := filesystemMeta{}
+	err = json.Unmarshal(metaJSON, &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	info.CacheControl = meta.CacheControl
+	info.ContentDisposition = meta.ContentDisposition
+	info.ContentEncoding = meta.ContentEncoding
+	info.ContentLanguage = meta.ContentLanguage
+	info.ContentType = meta.ContentType
+	info.Tags = meta.Tags
+	for name, value := range meta.UserMetadata {
+		info.UserMetadata[name] = value
+	}
+	info.ETag = meta.ETag
+	if info.ETag == "" {
+		info.ETag = meta.MD5
+	}
+
+	return info, nil
+}
+
+// write to a temp file in the destination directory then rename it into place,
+// a negative size skips the size check
+func writeFileAtomic(path string, reader io.Reader, size int64) (int64, error) {
+	dir := filepath.Dir(path)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, ".archie-tmp-*")
+	if err != nil {
+		return 0, err
+	}
+
+	cleanup := func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}
+
+	written, err := io.Copy(tmpFile, reader)
+	if err != nil {
+		cleanup()
+		return written, err
+	}
+
+	if size >= 0 && written != size {
+		cleanup()
+		return written, fmt.Errorf("wrote %d bytes but expected %d bytes", written, size)
+	}
+
+	err = tmpFile.Sync()
+	if err != nil {
+		cleanup()
+		return written, err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return written, err
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return written, err
+	}
+
+	return written, nil
+}
+
+// remove empty directories from dir up to, but not including, stop
+func removeEmptyDirs(dir string, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop) {
+		if os.Remove(dir) != nil {
+			// not empty or already gone
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// closingReader closes the file once it has been read to the end, or when an abandoned read closes it
+type closingReader struct {
+	file *os.File
+	err  error
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.file.Read(p)
+	if err != nil {
+		r.err = err
+		_ = r.file.Close()
+	}
+	return n, err
+}
+
+func (r *closingReader) Close() error {
+	if r.err != nil {
+		// already closed at the end of the file
+		return nil
+	}
+	r.err = os.ErrClosed
+	return r.file.Close()
+}
+
+// contextReader stops a copy once the context is canceled
+func contextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return reader.Read(p)
+	})
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (rf readerFunc) Read(p []byte) (int, error) {
+	return rf(p)
+}
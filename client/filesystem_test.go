@@ -0,0 +1,65 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClosingReaderClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "object")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &closingReader{file: file}
+
+	// an abandoned transfer only reads part of the file
+	if _, err := reader.Read(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	var closer io.Closer = reader
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the file is still open after Close: %v", err)
+	}
+	if _, err := reader.Read(make([]byte, 4)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read after Close = %v, want os.ErrClosed", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Errorf("a second Close = %v", err)
+	}
+}
+
+func TestClosingReaderEOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "object")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &closingReader{file: file}
+
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+	if _, err := file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the file is still open at the end of the file: %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Errorf("Close after the end of the file = %v", err)
+	}
+}
@@ -4,23 +4,37 @@ import (
 	"cloud.google.com/go/storage"
 	_ "cloud.google.com/go/storage"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"io"
+	"strconv"
+	"sync"
 )
 
+// the upload buffers are taken per put, the workers upload to the same client concurrently
+var gcsBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, 100*1024*1024) // 100 MB
+		return &buffer
+	},
+}
+
 type GCS struct {
 	client   *storage.Client
-	buffer   *[]byte
 	endpoint string
 }
 
 type GCSObject struct {
-	Bucket string
-	Path   string
-	Reader io.Reader
-	Client *storage.Client
+	Bucket     string
+	Path       string
+	Reader     io.Reader
+	Client     *storage.Client
+	Generation int64
 }
 
 func (g *GCS) New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc {
@@ -43,7 +57,7 @@ func (g *GCS) New(ctx context.Context, name, bucket, endpoint string, creds Cred
 	// TODO: turn on debug in gcs client
 	//if logLevel == zerolog.TraceLevel
 
-	if p == (Params{}) {
+	if p.Threads == 0 && p.PartSize == 0 {
 		log.Info().Msgf("Setup %s client to %s", name, "GCS")
 	} else {
 		log.Info().Msgf("Setup %s client to %s with %d threads and %dMB part size", name, "GCS", p.Threads, p.PartSize)
@@ -64,28 +78,56 @@ func (g *GCS) New(ctx context.Context, name, bucket, endpoint string, creds Cred
 
 	g.client = client
 
-	buffer := make([]byte, 100*1024*1024) // 100 MB
-	g.buffer = &buffer
-
 	return healthCheckCancel
 }
 
-func (g *GCS) GetObject(ctx context.Context, bucket string, key string) (Object, error) {
-	obj, err := g.client.Bucket(bucket).Object(key).NewReader(ctx)
+func (g *GCS) GetObject(ctx context.Context, bucket string, key string, opts GetOptions) (Object, error) {
+	handle, err := gcsObject(g.client, bucket, key, opts.VersionID)
 	if err != nil {
 		return nil, err
 	}
-	var mo Object = &GCSObject{Bucket: bucket, Path: key, Reader: obj, Client: g.client}
+
+	obj, err := handle.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the stat is pinned to the generation being read
+	var mo Object = &GCSObject{Bucket: bucket, Path: key, Reader: obj, Client: g.client, Generation: obj.Attrs.Generation}
 	return mo, nil
 }
 
 func (g *GCS) PutObject(ctx context.Context, bucket string, key string, reader io.Reader, objectSize int64, opts PutOptions) (UploadInfo, error) {
+	// gcs only retains the objects by the bucket's retention policy
+	if opts.RetentionMode != "" {
+		return UploadInfo{}, ErrRetentionNotSupported
+	}
+
 	writer := g.client.Bucket(bucket).Object(key).NewWriter(ctx)
+	writer.CacheControl = opts.CacheControl
 	writer.ChunkSize = int(opts.PartSize)
+	writer.ContentDisposition = opts.ContentDisposition
+	writer.ContentEncoding = opts.ContentEncoding
+	writer.ContentLanguage = opts.ContentLanguage
 	writer.ContentType = opts.ContentType
+	writer.Metadata = putUserMetadata(opts)
 	writer.Size = objectSize
+	writer.StorageClass = opts.StorageClass
+	writer.TemporaryHold = opts.LegalHold
 
-	_, err := io.CopyBuffer(writer, reader, *g.buffer)
+	// gcs fails the upload when the content doesn't match the checksum known up front
+	switch algorithm, sum := putChecksum(opts); algorithm {
+	case ChecksumMD5:
+		writer.MD5 = sum
+	case ChecksumCRC32C:
+		if len(sum) == 4 {
+			writer.CRC32C = binary.BigEndian.Uint32(sum)
+			writer.SendCRC32C = true
+		}
+	}
+
+	buffer := gcsBuffers.Get().(*[]byte)
+	_, err := io.CopyBuffer(writer, reader, *buffer)
+	gcsBuffers.Put(buffer)
 	if err != nil {
 		return UploadInfo{}, err
 	}
@@ -95,11 +137,51 @@ func (g *GCS) PutObject(ctx context.Context, bucket string, key string, reader i
 		return UploadInfo{}, err
 	}
 
-	return UploadInfo{}, nil
+	return UploadInfo{Checksum: gcsChecksum(writer.Attrs(), opts.Checksum)}, nil
+}
+
+func (g *GCS) CopyObject(ctx context.Context, srcBucket string, srcKey string, bucket string, key string, opts PutOptions) (UploadInfo, error) {
+	if opts.RetentionMode != "" {
+		return UploadInfo{}, ErrRetentionNotSupported
+	}
+
+	src, err := gcsObject(g.client, srcBucket, srcKey, opts.SourceVersionID)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	// the copier rewrites the object in as many calls as gcs needs
+	copier := g.client.Bucket(bucket).Object(key).CopierFrom(src)
+	copier.CacheControl = opts.CacheControl
+	copier.ContentDisposition = opts.ContentDisposition
+	copier.ContentEncoding = opts.ContentEncoding
+	copier.ContentLanguage = opts.ContentLanguage
+	copier.ContentType = opts.ContentType
+	copier.Metadata = putUserMetadata(opts)
+	copier.StorageClass = opts.StorageClass
+	copier.TemporaryHold = opts.LegalHold
+
+	attrs, err := copier.Run(ctx)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	return UploadInfo{Checksum: gcsChecksum(attrs, opts.Checksum)}, nil
 }
 
-func (g *GCS) RemoveObject(ctx context.Context, bucket string, key string) error {
-	if err := g.client.Bucket(bucket).Object(key).Delete(ctx); err != nil {
+func (g *GCS) UpdateMetadata(ctx context.Context, bucket string, key string, metadata map[string]string) error {
+	// the metadata is patched so the other names are kept
+	_, err := g.client.Bucket(bucket).Object(key).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return err
+}
+
+func (g *GCS) RemoveObject(ctx context.Context, bucket string, key string, opts RemoveOptions) error {
+	handle, err := gcsObject(g.client, bucket, key, opts.VersionID)
+	if err != nil {
+		return err
+	}
+
+	if err := handle.Delete(ctx); err != nil {
 		if err != nil {
 			return err
 		}
@@ -107,6 +189,58 @@ func (g *GCS) RemoveObject(ctx context.Context, bucket string, key string) error
 	return nil
 }
 
+func (g *GCS) ListObjects(ctx context.Context, bucket string, prefix string, opts ListOptions) <-chan ObjectInfo {
+	objectCh := make(chan ObjectInfo)
+
+	go func() {
+		defer close(objectCh)
+
+		// the start offset is inclusive
+		it := g.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix, StartOffset: opts.StartAfter, Versions: opts.Versions})
+		for {
+			var info ObjectInfo
+
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return
+			} else if err != nil {
+				info.Err = err
+			} else if attrs.Name == opts.StartAfter {
+				continue
+			} else {
+				info = gcsObjectInfo(attrs)
+			}
+
+			select {
+			case objectCh <- info:
+			case <-ctx.Done():
+				return
+			}
+
+			if info.Err != nil {
+				return
+			}
+		}
+	}()
+
+	return objectCh
+}
+
+func (g *GCS) StatObject(ctx context.Context, bucket string, key string, opts GetOptions) (*ObjectInfo, error) {
+	handle, err := gcsObject(g.client, bucket, key, opts.VersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := handle.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := gcsObjectInfo(attrs)
+	return &info, nil
+}
+
 func (g *GCS) IsOffline() bool {
 	// the gcs library doesn't offer a health-check
 	return false
@@ -117,13 +251,74 @@ func (g *GCS) EndpointURL() string {
 }
 
 func (o *GCSObject) Stat(ctx context.Context) (*ObjectInfo, error) {
-	obj, err := o.Client.Bucket(o.Bucket).Object(o.Path).Attrs(ctx)
+	obj, err := o.Client.Bucket(o.Bucket).Object(o.Path).Generation(o.Generation).Attrs(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &ObjectInfo{Size: obj.Size, ContentType: obj.ContentType, ETag: obj.Etag}, nil
+	info := gcsObjectInfo(obj)
+	return &info, nil
 }
 
 func (o *GCSObject) GetReader() io.Reader {
 	return o.Reader
 }
+
+func gcsObjectInfo(attrs *storage.ObjectAttrs) ObjectInfo {
+	// gcs has no object tags
+	info := ObjectInfo{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		ETag:               attrs.Etag,
+		IsLatest:           attrs.Deleted.IsZero(),
+		Key:                attrs.Name,
+		LastModified:       attrs.Updated,
+		Size:               attrs.Size,
+		StorageClass:       attrs.StorageClass,
+		UserMetadata:       map[string]string{},
+		VersionID:          strconv.FormatInt(attrs.Generation, 10),
+	}
+
+	for name, value := range attrs.Metadata {
+		info.UserMetadata[name] = value
+	}
+	if eTag, ok := attrs.Metadata[ETagMetadata]; ok {
+		info.ETag = eTag
+	}
+
+	return info
+}
+
+// the checksum gcs computed for the algorithm, empty when it doesn't have one
+func gcsChecksum(attrs *storage.ObjectAttrs, algorithm string) string {
+	if attrs == nil {
+		return ""
+	}
+
+	switch algorithm {
+	case ChecksumCRC32C:
+		return fmt.Sprintf("%08x", attrs.CRC32C)
+	case ChecksumMD5:
+		// composite objects don't have an md5
+		if len(attrs.MD5) > 0 {
+			return hex.EncodeToString(attrs.MD5)
+		}
+	}
+	return ""
+}
+
+// the object handle for a version, gcs uses the generation as the version id
+func gcsObject(client *storage.Client, bucket string, key string, versionID string) (*storage.ObjectHandle, error) {
+	handle := client.Bucket(bucket).Object(key)
+	if versionID == "" {
+		return handle, nil
+	}
+
+	generation, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gcs generation %s: %w", versionID, err)
+	}
+	return handle.Generation(generation), nil
+}
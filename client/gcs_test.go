@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGCS stores the objects of the multipart uploads
+type fakeGCS struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/") {
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+
+	var attrs struct {
+		Bucket string `json:"bucket"`
+		Name   string `json:"name"`
+	}
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	part, err = parts.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.lock.Lock()
+	f.objects[attrs.Name] = data
+	f.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"bucket": attrs.Bucket,
+		"name":   attrs.Name,
+		"size":   fmt.Sprint(len(data)),
+	})
+}
+
+func TestGCSConcurrentPutObject(t *testing.T) {
+	fake := &fakeGCS{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+
+	ctx := context.Background()
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storageClient.Close()
+
+	g := &GCS{client: storageClient}
+
+	// each put streams its own byte value, a shared buffer mixes them up
+	const puts = 4
+	const objectSize = 8 * 1024 * 1024
+
+	var wg sync.WaitGroup
+	errs := make(chan error, puts)
+	for i := 0; i < puts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i)}, objectSize)
+			// hide the WriterTo so the copy goes through the upload buffer
+			reader := struct{ io.Reader }{bytes.NewReader(data)}
+			_, err := g.PutObject(ctx, "bucket", fmt.Sprintf("object-%d", i), reader, objectSize, PutOptions{})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < puts; i++ {
+		key := fmt.Sprintf("object-%d", i)
+		want := bytes.Repeat([]byte{byte('a' + i)}, objectSize)
+		if !bytes.Equal(fake.objects[key], want) {
+			t.Errorf("%s was stored with the bytes of another put", key)
+		}
+	}
+}
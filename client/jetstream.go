@@ -11,24 +11,10 @@ func JetStream(
 	streamReplicas, maxAckPending int,
 	streamMaxSize int64,
 	msgTimeout, streamRetention, streamRepublishSubject string,
+	deadLetterSubject, deadLetterStream, deadLetterMaxAgeDur string,
 	provisioningDisabled bool,
 ) (*nats.Subscription, *nats.Conn) {
-	// reconnect forever
-	connectOptions := []nats.Option{nats.MaxReconnects(-1)}
-
-	if rootCA != "" {
-		connectOptions = append(connectOptions, nats.RootCAs(rootCA))
-	}
-	if username != "" && password != "" {
-		connectOptions = append(connectOptions, nats.UserInfo(username, password))
-	}
-
-	natsClient, err := nats.Connect(url, connectOptions...)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to setup JetStream client")
-	}
-
-	log.Info().Msgf("Connected to nats at %s", natsClient.ConnectedUrl())
+	natsClient := Connect(url, rootCA, username, password)
 
 	jetStream, err := natsClient.JetStream()
 	if err != nil {
@@ -84,6 +70,29 @@ func JetStream(
 		log.Info().Msgf("JetStream stream %s configured with %d replicas and limited by %s max age, and %d max bytes",
 			streamInfo.Config.Name, streamInfo.Config.Replicas, streamInfo.Config.MaxAge, streamInfo.Config.MaxBytes)
 
+		// build the dead letter stream for terminated messages
+		if deadLetterSubject != "" {
+			deadLetterConfig := &nats.StreamConfig{
+				Name:      deadLetterStream,
+				Subjects:  []string{deadLetterSubject},
+				Replicas:  streamReplicas,
+				Retention: nats.LimitsPolicy,
+			}
+
+			if deadLetterMaxAgeDur != "" {
+				deadLetterMaxAge, err := time.ParseDuration(deadLetterMaxAgeDur)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Failed to parse jetstream-dead-letter-max-age duration argument")
+				}
+				deadLetterConfig.MaxAge = deadLetterMaxAge
+			}
+
+			deadLetterInfo := createOrUpdateStream(jetStream, deadLetterStream, deadLetterConfig)
+
+			log.Info().Msgf("JetStream dead letter stream %s configured on subject %s with %d replicas and limited by %s max age",
+				deadLetterInfo.Config.Name, deadLetterSubject, deadLetterInfo.Config.Replicas, deadLetterInfo.Config.MaxAge)
+		}
+
 		// build the stream consumer
 		ackWait, err := time.ParseDuration(msgTimeout)
 		if err != nil {
@@ -137,6 +146,27 @@ func JetStream(
 	return sub, natsClient
 }
 
+func Connect(url, rootCA, username, password string) *nats.Conn {
+	// reconnect forever
+	connectOptions := []nats.Option{nats.MaxReconnects(-1)}
+
+	if rootCA != "" {
+		connectOptions = append(connectOptions, nats.RootCAs(rootCA))
+	}
+	if username != "" && password != "" {
+		connectOptions = append(connectOptions, nats.UserInfo(username, password))
+	}
+
+	natsClient, err := nats.Connect(url, connectOptions...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to setup JetStream client")
+	}
+
+	log.Info().Msgf("Connected to nats at %s", natsClient.ConnectedUrl())
+
+	return natsClient
+}
+
 func createOrUpdateStream(jetStream nats.JetStreamContext, stream string, streamConfig *nats.StreamConfig) *nats.StreamInfo {
 	streamInfo, err := jetStream.StreamInfo(stream)
 	if err != nil {
@@ -156,3 +186,42 @@ func createOrUpdateStream(jetStream nats.JetStreamContext, stream string, stream
 	}
 	return streamInfo
 }
+
+func KeyValue(natsClient *nats.Conn, bucket, ttlDur string, replicas int, provisioningDisabled bool) nats.KeyValue {
+	jetStream, err := natsClient.JetStream()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize JetStream context")
+	}
+
+	keyValue, err := jetStream.KeyValue(bucket)
+	if err == nil {
+		log.Info().Msgf("JetStream key-value bucket %s bound", bucket)
+		return keyValue
+	} else if err != nats.ErrBucketNotFound || provisioningDisabled {
+		log.Fatal().Err(err).Msgf("Failed to bind the JetStream key-value bucket %s", bucket)
+	}
+
+	keyValueConfig := &nats.KeyValueConfig{
+		Bucket:   bucket,
+		History:  1,
+		Replicas: replicas,
+	}
+
+	if ttlDur != "" {
+		ttl, err := time.ParseDuration(ttlDur)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Failed to parse the key-value bucket %s ttl duration", bucket)
+		}
+		keyValueConfig.TTL = ttl
+	}
+
+	keyValue, err = jetStream.CreateKeyValue(keyValueConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to add the JetStream key-value bucket %s", bucket)
+	}
+
+	log.Info().Msgf("JetStream key-value bucket %s configured with %d replicas and %s ttl",
+		bucket, keyValueConfig.Replicas, keyValueConfig.TTL)
+
+	return keyValue
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"strings"
+)
+
+// ETagMetadata is the user metadata name archie stores the source ETag under
+const ETagMetadata = "Minio-Etag"
+
+// SourceVersionMetadata is the user metadata name archie stores the source version id under
+const SourceVersionMetadata = "Archie-Source-Version"
+
+// OriginalSizeMetadata is the user metadata name archie stores the source size under
+// when the object is encrypted or compressed on its way to the destination
+const OriginalSizeMetadata = "Archie-Original-Size"
+
+// SourceKeyMetadata is the user metadata name archie stores the path escaped source key under
+// when the destination key is rewritten, so a restore can find the source key again
+const SourceKeyMetadata = "Archie-Source-Key"
+
+// the user metadata names of the compression and encryption envelope recorded with a destination object
+const (
+	CompressionMetadata       = "Archie-Compression"
+	EncryptionDataKeyMetadata = "Archie-Encryption-Data-Key"
+	EncryptionKeyIDMetadata   = "Archie-Encryption-Key-Id"
+	EncryptionMetadata        = "Archie-Encryption"
+	EncryptionNonceMetadata   = "Archie-Encryption-Nonce"
+)
+
+// the names the clients put with every object themselves
+var clientMetadata = []string{ETagMetadata, SourceVersionMetadata}
+
+// every name archie records on a destination object
+var internalMetadata = append([]string{
+	ChecksumMetadata,
+	CompressionMetadata,
+	EncryptionDataKeyMetadata,
+	EncryptionKeyIDMetadata,
+	EncryptionMetadata,
+	EncryptionNonceMetadata,
+	OriginalSizeMetadata,
+	SourceKeyMetadata,
+}, clientMetadata...)
+
+// IsInternalMetadata reports if the user metadata name is one archie manages itself
+func IsInternalMetadata(name string) bool {
+	return isMetadataName(name, internalMetadata)
+}
+
+func isMetadataName(name string, names []string) bool {
+	for _, internal := range names {
+		if sameMetadataName(name, internal) {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataValue finds a user metadata value by any of its s3, gcs or azure spellings
+func MetadataValue(metadata map[string]string, name string) string {
+	for metadataName, value := range metadata {
+		if sameMetadataName(metadataName, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// the names are matched without case or dashes since s3 title cases them and older versions dropped the dashes on azure
+func sameMetadataName(a string, b string) bool {
+	return strings.EqualFold(strings.ReplaceAll(a, "-", ""), strings.ReplaceAll(b, "-", ""))
+}
+
+// the user metadata to put with the object, the source ETag and version are added under their own names
+// and the internal names archie passes in are kept
+func putUserMetadata(opts PutOptions) map[string]string {
+	userMetadata := map[string]string{}
+	for name, value := range opts.UserMetadata {
+		if !isMetadataName(name, clientMetadata) {
+			userMetadata[name] = value
+		}
+	}
+	if opts.ETag != "" {
+		userMetadata[ETagMetadata] = opts.ETag
+	}
+	if opts.SourceVersionID != "" {
+		userMetadata[SourceVersionMetadata] = opts.SourceVersionID
+	}
+	return userMetadata
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestIsInternalMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Minio-Etag", true},
+		{"MinioEtag", true},
+		{"Archie-Checksum", true},
+		{"archie-checksum", true},
+		{"archie-source-version", true},
+		{"Archie-Original-Size", true},
+		{"ArchieSourceKey", true},
+		{"Archie-Compression", true},
+		{"Archie-Encryption", true},
+		{"Archie-Encryption-Data-Key", true},
+		{"archie-encryption-key-id", true},
+		{"Archie-Encryption-Nonce", true},
+		{"Archie-Custom", false},
+		{"Owner", false},
+	}
+
+	for _, test := range tests {
+		if got := IsInternalMetadata(test.name); got != test.want {
+			t.Errorf("IsInternalMetadata(%q) = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPutUserMetadata(t *testing.T) {
+	userMetadata := putUserMetadata(PutOptions{
+		ETag:            "new-etag",
+		SourceVersionID: "v2",
+		UserMetadata: map[string]string{
+			"Archie-Checksum": "md5:9e107d9d372bb6826bd81d3542a419d6",
+			"Minio-Etag":      "old-etag",
+			"Owner":           "minehut",
+		},
+	})
+
+	want := map[string]string{
+		ETagMetadata:          "new-etag",
+		SourceVersionMetadata: "v2",
+		"Archie-Checksum":     "md5:9e107d9d372bb6826bd81d3542a419d6",
+		"Owner":               "minehut",
+	}
+	if len(userMetadata) != len(want) {
+		t.Fatalf("put %v, want %v", userMetadata, want)
+	}
+	for name, value := range want {
+		if userMetadata[name] != value {
+			t.Errorf("%s = %q, want %q", name, userMetadata[name], value)
+		}
+	}
+}
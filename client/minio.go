@@ -8,17 +8,30 @@ import (
 	"github.com/rs/zerolog/log"
 	"io"
 	"os"
+	"strings"
 	"time"
 )
 
+// the storage class is set through the user metadata when copying
+const minioStorageClassHeader = "X-Amz-Storage-Class"
+
+// the object lock headers of a stat
+const (
+	minioLegalHoldHeader     = "X-Amz-Object-Lock-Legal-Hold"
+	minioRetainUntilHeader   = "X-Amz-Object-Lock-Retain-Until-Date"
+	minioRetentionModeHeader = "X-Amz-Object-Lock-Mode"
+)
+
 type Minio struct {
-	client *minio.Client
+	client       *minio.Client
+	listMetadata bool
 }
 
 type MinioObject struct {
 	Bucket string
 	Path   string
 	Reader io.Reader
+	Client *minio.Client
 }
 
 func (m *Minio) New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc {
@@ -26,18 +39,28 @@ func (m *Minio) New(ctx context.Context, name, bucket, endpoint string, creds Cr
 	//minio.MaxRetry = 0
 
 	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(creds.MinioAccessKey, creds.MinioSecretAccessKey, ""),
-		Secure: useSSL,
+		Creds:        credentials.NewStaticV4(creds.MinioAccessKey, creds.MinioSecretAccessKey, ""),
+		Secure:       useSSL,
+		Region:       p.Region,
+		BucketLookup: bucketLookup(p.BucketLookup),
 	})
 	if err != nil {
 		log.Fatal().Err(err).Msgf("Failed to setup %s client", name)
 	}
 
+	// minio servers can include the user metadata in listings
+	m.listMetadata = true
+
+	return m.setup(ctx, name, bucket, client, p, logLevel)
+}
+
+// setup finishes configuring any minio-go based client
+func (m *Minio) setup(ctx context.Context, name, bucket string, client *minio.Client, p Params, logLevel zerolog.Level) context.CancelFunc {
 	if logLevel == zerolog.TraceLevel {
 		client.TraceOn(os.Stdout)
 	}
 
-	if p == (Params{}) {
+	if p.Threads == 0 && p.PartSize == 0 {
 		log.Info().Msgf("Setup %s client to %s", name, client.EndpointURL())
 	} else {
 		log.Info().Msgf("Setup %s client to %s with %d threads and %dMB part size", name, client.EndpointURL(), p.Threads, p.PartSize)
@@ -63,49 +86,211 @@ func (m *Minio) New(ctx context.Context, name, bucket, endpoint string, creds Cr
 	return healthCheckCancel
 }
 
-func (m *Minio) GetObject(ctx context.Context, bucket string, key string) (Object, error) {
-	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+func (m *Minio) GetObject(ctx context.Context, bucket string, key string, opts GetOptions) (Object, error) {
+	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{VersionID: opts.VersionID})
 	if err != nil {
 		return nil, err
 	}
-	var mo Object = &MinioObject{Bucket: bucket, Path: key, Reader: obj}
+	var mo Object = &MinioObject{Bucket: bucket, Path: key, Reader: obj, Client: m.client}
 	return mo, nil
 }
 
 func (m *Minio) PutObject(ctx context.Context, bucket string, key string, reader io.Reader, objectSize int64, opts PutOptions) (UploadInfo, error) {
 	putOpts := minio.PutObjectOptions{
-		ContentType:    opts.ContentType,
-		NumThreads:     opts.NumThreads,
-		PartSize:       opts.PartSize,
-		SendContentMd5: true,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        opts.ContentType,
+		NumThreads:         opts.NumThreads,
+		PartSize:           opts.PartSize,
+		SendContentMd5:     true,
+		StorageClass:       opts.StorageClass,
+		UserMetadata:       putUserMetadata(opts),
+		UserTags:           opts.Tags,
+	}
+	if opts.RetentionMode != "" {
+		putOpts.Mode = minio.RetentionMode(opts.RetentionMode)
+		putOpts.RetainUntilDate = opts.RetainUntil
+	}
+	if opts.LegalHold {
+		putOpts.LegalHold = minio.LegalHoldEnabled
 	}
 
-	if opts.ETag != "" {
-		putOpts.UserMetadata = map[string]string{
-			"Minio-Etag": opts.ETag,
+	// a multipart upload's etag is checked against the md5 of the parts it was split into
+	var parts *multipartMD5
+	if opts.Checksum == ChecksumMD5 {
+		_, partSize, _, err := minio.OptimalPartInfo(objectSize, opts.PartSize)
+		if err == nil {
+			parts = newMultipartMD5(partSize)
+			reader = io.TeeReader(reader, parts)
 		}
 	}
-	_, err := m.client.PutObject(ctx, bucket, key, reader, objectSize, putOpts)
+
+	uploadInfo, err := m.client.PutObject(ctx, bucket, key, reader, objectSize, putOpts)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+
+	if parts != nil && strings.Contains(uploadInfo.ETag, "-") {
+		return UploadInfo{Checksum: parts.checksum(uploadInfo.ETag)}, nil
+	}
+	return UploadInfo{Checksum: minioChecksum(uploadInfo, opts.Checksum)}, nil
+}
+
+func (m *Minio) CopyObject(ctx context.Context, srcBucket string, srcKey string, bucket string, key string, opts PutOptions) (UploadInfo, error) {
+	// the standard headers are replaced along with the user metadata
+	userMetadata := minioHeaders(opts.ContentType, opts.CacheControl, opts.ContentDisposition, opts.ContentEncoding, opts.ContentLanguage)
+	for name, value := range putUserMetadata(opts) {
+		userMetadata[name] = value
+	}
+	if opts.StorageClass != "" {
+		userMetadata[minioStorageClassHeader] = opts.StorageClass
+	}
+
+	destOpts := minio.CopyDestOptions{
+		Bucket:          bucket,
+		Object:          key,
+		ReplaceMetadata: true,
+		ReplaceTags:     true,
+		UserMetadata:    userMetadata,
+		UserTags:        opts.Tags,
+	}
+	if opts.RetentionMode != "" {
+		destOpts.Mode = minio.RetentionMode(opts.RetentionMode)
+		destOpts.RetainUntilDate = opts.RetainUntil
+	}
+	if opts.LegalHold {
+		destOpts.LegalHold = minio.LegalHoldEnabled
+	}
+
+	// compose copies the objects over the 5GiB single copy limit in parts
+	uploadInfo, err := m.client.ComposeObject(ctx,
+		destOpts,
+		minio.CopySrcOptions{
+			Bucket:    srcBucket,
+			Object:    srcKey,
+			VersionID: opts.SourceVersionID,
+		},
+	)
 	if err != nil {
 		return UploadInfo{}, err
 	}
-	return UploadInfo{}, nil
+
+	return UploadInfo{Checksum: minioChecksum(uploadInfo, opts.Checksum)}, nil
+}
+
+func (m *Minio) UpdateMetadata(ctx context.Context, bucket string, key string, metadata map[string]string) error {
+	objInfo, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	// s3 metadata can't be changed in place, the object is copied onto itself with the merged metadata
+	userMetadata := minioHeaders(
+		objInfo.ContentType,
+		objInfo.Metadata.Get("Cache-Control"),
+		objInfo.Metadata.Get("Content-Disposition"),
+		objInfo.Metadata.Get("Content-Encoding"),
+		objInfo.Metadata.Get("Content-Language"),
+	)
+	for name, value := range objInfo.UserMetadata {
+		userMetadata[name] = value
+	}
+	for name, value := range metadata {
+		userMetadata[name] = value
+	}
+	// the copy would otherwise land in the bucket's default class
+	if objInfo.StorageClass != "" {
+		userMetadata[minioStorageClassHeader] = objInfo.StorageClass
+	}
+
+	// the new version keeps the lock of the one it replaces
+	destOpts := minio.CopyDestOptions{
+		Bucket:          bucket,
+		Object:          key,
+		ReplaceMetadata: true,
+		UserMetadata:    userMetadata,
+		LegalHold:       minio.LegalHoldStatus(objInfo.Metadata.Get(minioLegalHoldHeader)),
+	}
+	if mode := objInfo.Metadata.Get(minioRetentionModeHeader); mode != "" {
+		destOpts.Mode = minio.RetentionMode(mode)
+		destOpts.RetainUntilDate, err = time.Parse(time.RFC3339, objInfo.Metadata.Get(minioRetainUntilHeader))
+		if err != nil {
+			return err
+		}
+	}
+
+	// compose handles the objects over the 5GiB single copy limit
+	_, err = m.client.ComposeObject(ctx,
+		destOpts,
+		// a newer write in between fails the copy instead of being replaced by the stat object
+		minio.CopySrcOptions{
+			Bucket:    bucket,
+			MatchETag: objInfo.ETag,
+			Object:    key,
+			VersionID: objInfo.VersionID,
+		},
+	)
+	return err
 }
 
-func (m *Minio) RemoveObject(ctx context.Context, bucket string, key string) error {
+func (m *Minio) RemoveObject(ctx context.Context, bucket string, key string, opts RemoveOptions) error {
 	// removeObject doesn't return an error if the key doesn't exist so check first
-	_, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	_, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{VersionID: opts.VersionID})
 	if err != nil {
 		return err
 	}
 
-	err = m.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+	err = m.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{VersionID: opts.VersionID})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (m *Minio) ListObjects(ctx context.Context, bucket string, prefix string, opts ListOptions) <-chan ObjectInfo {
+	objectCh := make(chan ObjectInfo)
+
+	go func() {
+		defer close(objectCh)
+
+		listOpts := minio.ListObjectsOptions{
+			Prefix:       prefix,
+			Recursive:    true,
+			StartAfter:   opts.StartAfter,
+			WithMetadata: m.listMetadata,
+			WithVersions: opts.Versions,
+		}
+
+		for obj := range m.client.ListObjects(ctx, bucket, listOpts) {
+			info := minioObjectInfo(obj, true)
+
+			select {
+			case objectCh <- info:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return objectCh
+}
+
+func (m *Minio) StatObject(ctx context.Context, bucket string, key string, opts GetOptions) (*ObjectInfo, error) {
+	objInfo, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{VersionID: opts.VersionID})
+	if err != nil {
+		return nil, err
+	}
+
+	info := minioObjectInfo(objInfo, false)
+	info.Tags, err = minioTags(ctx, m.client, bucket, key, objInfo.VersionID, objInfo.UserTagCount)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
 func (m *Minio) IsOffline() bool {
 	return m.client.IsOffline()
 }
@@ -114,17 +299,109 @@ func (m *Minio) EndpointURL() string {
 	return m.client.EndpointURL().String()
 }
 
+func bucketLookup(lookup string) minio.BucketLookupType {
+	switch lookup {
+	case "dns":
+		return minio.BucketLookupDNS
+	case "path":
+		return minio.BucketLookupPath
+	}
+	return minio.BucketLookupAuto
+}
+
 func (o *MinioObject) Stat(ctx context.Context) (*ObjectInfo, error) {
 	srcStat, err := o.Reader.(*minio.Object).Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	userMeta := srcStat.UserMetadata
-
-	return &ObjectInfo{Size: srcStat.Size, ContentType: srcStat.ContentType, ETag: userMeta["Minio-Etag"]}, nil
+	info := minioObjectInfo(srcStat, false)
+	info.Tags, err = minioTags(ctx, o.Client, o.Bucket, o.Path, srcStat.VersionID, srcStat.UserTagCount)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
 }
 
 func (o *MinioObject) GetReader() io.Reader {
 	return o.Reader
 }
+
+func minioObjectInfo(objInfo minio.ObjectInfo, listed bool) ObjectInfo {
+	info := ObjectInfo{
+		CacheControl:       objInfo.Metadata.Get("Cache-Control"),
+		ContentDisposition: objInfo.Metadata.Get("Content-Disposition"),
+		ContentEncoding:    objInfo.Metadata.Get("Content-Encoding"),
+		ContentLanguage:    objInfo.Metadata.Get("Content-Language"),
+		ContentType:        objInfo.ContentType,
+		ETag:               objInfo.ETag,
+		Err:                objInfo.Err,
+		IsDeleteMarker:     objInfo.IsDeleteMarker,
+		IsLatest:           objInfo.IsLatest,
+		Key:                objInfo.Key,
+		LastModified:       objInfo.LastModified,
+		Size:               objInfo.Size,
+		StorageClass:       objInfo.StorageClass,
+		Tags:               objInfo.UserTags,
+		UserMetadata:       map[string]string{},
+		VersionID:          objInfo.VersionID,
+	}
+
+	// a stat strips the amz prefix from the metadata names, a listing keeps it and mixes in other headers
+	for name, value := range objInfo.UserMetadata {
+		if listed {
+			if !strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				continue
+			}
+			name = name[len("x-amz-meta-"):]
+		}
+		info.UserMetadata[name] = value
+	}
+
+	// objects not written by archie only have their own ETag
+	for name, value := range info.UserMetadata {
+		if strings.EqualFold(name, ETagMetadata) {
+			info.ETag = value
+		}
+	}
+
+	return info
+}
+
+// a stat only counts the tags so they're fetched separately when there are any
+func minioTags(ctx context.Context, client *minio.Client, bucket string, key string, versionID string, tagCount int) (map[string]string, error) {
+	if tagCount == 0 {
+		return nil, nil
+	}
+
+	objectTags, err := client.GetObjectTagging(ctx, bucket, key, minio.GetObjectTaggingOptions{VersionID: versionID})
+	if err != nil {
+		return nil, err
+	}
+	return objectTags.ToMap(), nil
+}
+
+// the standard headers are set through the user metadata when copying, empty ones are left out
+func minioHeaders(contentType, cacheControl, contentDisposition, contentEncoding, contentLanguage string) map[string]string {
+	headers := map[string]string{}
+	for name, value := range map[string]string{
+		"Cache-Control":       cacheControl,
+		"Content-Disposition": contentDisposition,
+		"Content-Encoding":    contentEncoding,
+		"Content-Language":    contentLanguage,
+		"Content-Type":        contentType,
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// only a single part upload's etag is the md5 of the content, a multipart one is checked by its parts
+func minioChecksum(uploadInfo minio.UploadInfo, algorithm string) string {
+	if algorithm == ChecksumMD5 && !strings.Contains(uploadInfo.ETag, "-") {
+		return strings.ToLower(uploadInfo.ETag)
+	}
+	return ""
+}
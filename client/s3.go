@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// S3 is a native AWS S3 client, it shares the object operations with the minio client
+// but resolves credentials with the aws chain (static, env, shared file, web identity, instance profile)
+type S3 struct {
+	Minio
+}
+
+func (s *S3) New(ctx context.Context, name, bucket, endpoint string, creds Credentials, useSSL bool, p Params, logLevel zerolog.Level) context.CancelFunc {
+	if endpoint == "" {
+		// aws always uses tls
+		endpoint = "s3.amazonaws.com"
+		if p.Region != "" {
+			endpoint = fmt.Sprintf("s3.%s.amazonaws.com", p.Region)
+		}
+		useSSL = true
+	}
+
+	s3Creds, err := awsCredentials(creds, p.Region)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to setup %s client credentials", name)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        s3Creds,
+		Secure:       useSSL,
+		Region:       p.Region,
+		BucketLookup: bucketLookup(p.BucketLookup),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to setup %s client", name)
+	}
+
+	return s.setup(ctx, name, bucket, client, p, logLevel)
+}
+
+func awsCredentials(creds Credentials, region string) (*credentials.Credentials, error) {
+	// assume a role with the static keys
+	if creds.AWSRoleARN != "" && creds.MinioAccessKey != "" && creds.MinioSecretAccessKey != "" {
+		stsEndpoint := creds.AWSSTSEndpoint
+		if stsEndpoint == "" {
+			stsEndpoint = "https://sts.amazonaws.com"
+		}
+
+		return credentials.NewSTSAssumeRole(stsEndpoint, credentials.STSAssumeRoleOptions{
+			AccessKey:       creds.MinioAccessKey,
+			SecretKey:       creds.MinioSecretAccessKey,
+			Location:        region,
+			RoleARN:         creds.AWSRoleARN,
+			RoleSessionName: creds.AWSRoleSessionName,
+		})
+	}
+
+	// static keys
+	if creds.MinioAccessKey != "" && creds.MinioSecretAccessKey != "" {
+		return credentials.NewStaticV4(creds.MinioAccessKey, creds.MinioSecretAccessKey, creds.AWSSessionToken), nil
+	}
+
+	// the iam provider covers web identity (AWS_WEB_IDENTITY_TOKEN_FILE),
+	// ecs task roles and the ec2 instance profile
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	}), nil
+}
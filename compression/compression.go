@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"archie/client"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"path"
+	"strings"
+)
+
+// the supported codecs
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// CodecMetadata is the destination user metadata name the codec of a compressed object is recorded under
+const CodecMetadata = client.CompressionMetadata
+
+// the content types that are already compressed, the media types also match by their prefix
+var compressedContentTypes = []string{
+	"application/gzip",
+	"application/java-archive",
+	"application/vnd.rar",
+	"application/x-7z-compressed",
+	"application/x-brotli",
+	"application/x-bzip2",
+	"application/x-compress",
+	"application/x-gzip",
+	"application/x-lz4",
+	"application/x-rar-compressed",
+	"application/x-xz",
+	"application/x-zstd",
+	"application/zip",
+	"application/zstd",
+	"audio/",
+	"image/",
+	"video/",
+}
+
+// the key extensions of the compressed formats, for the objects uploaded without a specific content type
+var compressedExtensions = []string{
+	".7z", ".avif", ".br", ".bz2", ".flac", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz4", ".mkv",
+	".mov", ".mp3", ".mp4", ".ogg", ".png", ".rar", ".tgz", ".webm", ".webp", ".xz", ".zip", ".zst",
+}
+
+// Valid reports if the codec is supported
+func Valid(codec string) bool {
+	return codec == Gzip || codec == Zstd
+}
+
+// Suffix is the key extension of the codec
+func Suffix(codec string) string {
+	switch codec {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// Compressed reports if an object is already compressed by its content encoding, content type or key extension,
+// compressing it again would only cost cpu
+func Compressed(key string, contentType string, contentEncoding string) bool {
+	if contentEncoding != "" && contentEncoding != "identity" {
+		return true
+	}
+
+	// the svg images are text
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType != "" && mediaType != "image/svg+xml" {
+		for _, compressed := range compressedContentTypes {
+			if mediaType == compressed || (strings.HasSuffix(compressed, "/") && strings.HasPrefix(mediaType, compressed)) {
+				return true
+			}
+		}
+	}
+
+	extension := strings.ToLower(path.Ext(key))
+	for _, compressed := range compressedExtensions {
+		if extension == compressed {
+			return true
+		}
+	}
+	return false
+}
+
+// Reader compresses the stream it reads from, Size is the compressed size read so far,
+// closing it stops the compression when the stream isn't read to the end
+type Reader struct {
+	pipeReader *io.PipeReader
+	size       int64
+}
+
+// Compress the stream with the codec while it's read
+func Compress(reader io.Reader, codec string) (*Reader, error) {
+	pipeReader, pipeWriter := io.Pipe()
+
+	var writer io.WriteCloser
+	switch codec {
+	case Gzip:
+		writer = gzip.NewWriter(pipeWriter)
+	case Zstd:
+		// the workers already compress in parallel
+		encoder, err := zstd.NewWriter(pipeWriter, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		writer = encoder
+	default:
+		return nil, fmt.Errorf("unknown compression codec %q", codec)
+	}
+
+	go func() {
+		_, err := io.Copy(writer, reader)
+		closeErr := writer.Close()
+		if err == nil {
+			err = closeErr
+		}
+		// nil ends the compressed stream normally
+		_ = pipeWriter.CloseWithError(err)
+	}()
+
+	return &Reader{pipeReader: pipeReader}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.pipeReader.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *Reader) Close() error {
+	return r.pipeReader.Close()
+}
+
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Decompress a stream compressed with the codec
+func Decompress(reader io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewReader(reader)
+	case Zstd:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", codec)
+}
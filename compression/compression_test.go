@@ -0,0 +1,173 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 256*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string][]byte{
+		"empty":      {},
+		"text":       bytes.Repeat([]byte("archie archives the minio objects\n"), 100000),
+		"random":     random,
+		"single one": {1},
+	}
+
+	for _, codec := range []string{Gzip, Zstd} {
+		for name, input := range inputs {
+			t.Run(codec+" "+name, func(t *testing.T) {
+				compressed, err := Compress(bytes.NewReader(input), codec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer compressed.Close()
+
+				// read through the pipe goroutine like the uploads do
+				data, err := io.ReadAll(compressed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if compressed.Size() != int64(len(data)) {
+					t.Errorf("Size() = %d, read %d bytes", compressed.Size(), len(data))
+				}
+
+				decompressed, err := Decompress(bytes.NewReader(data), codec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer decompressed.Close()
+
+				output, err := io.ReadAll(decompressed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(output, input) {
+					t.Errorf("the round trip changed the %d byte input to %d bytes", len(input), len(output))
+				}
+			})
+		}
+	}
+}
+
+// a source that fails partway through
+type failingReader struct {
+	err error
+	n   int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, f.err
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestSourceError(t *testing.T) {
+	sourceErr := errors.New("source failed")
+
+	for _, codec := range []string{Gzip, Zstd} {
+		t.Run(codec, func(t *testing.T) {
+			compressed, err := Compress(&failingReader{err: sourceErr, n: 1024}, codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer compressed.Close()
+
+			// the source error reaches the reader instead of a truncated stream
+			if _, err := io.ReadAll(compressed); !errors.Is(err, sourceErr) {
+				t.Errorf("read = %v, want %v", err, sourceErr)
+			}
+		})
+	}
+}
+
+// an endless source, only a stopped compression stops reading it
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return len(p), nil
+}
+
+func TestCloseStopsCompression(t *testing.T) {
+	for _, codec := range []string{Gzip, Zstd} {
+		t.Run(codec, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			compressed, err := Compress(endlessReader{}, codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the consumer stops reading partway, like a failed upload
+			if _, err := io.ReadFull(compressed, make([]byte, 1024)); err != nil {
+				t.Fatal(err)
+			}
+			if err := compressed.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d goroutines are still running after Close, %d before Compress", runtime.NumGoroutine(), before)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if _, err := compressed.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+				t.Errorf("Read after Close = %v, want %v", err, io.ErrClosedPipe)
+			}
+		})
+	}
+}
+
+func TestCompressUnknownCodec(t *testing.T) {
+	if _, err := Compress(bytes.NewReader(nil), "brotli"); err == nil {
+		t.Error("compressed with an unknown codec")
+	}
+	if _, err := Decompress(bytes.NewReader(nil), "brotli"); err == nil {
+		t.Error("decompressed with an unknown codec")
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	tests := []struct {
+		key             string
+		contentType     string
+		contentEncoding string
+		want            bool
+	}{
+		{"a.txt", "text/plain", "", false},
+		{"a.txt", "text/plain", "identity", false},
+		{"a.txt", "text/plain", "gzip", true},
+		{"a", "image/png", "", true},
+		{"a", "IMAGE/PNG; charset=binary", "", true},
+		{"a", "image/svg+xml", "", false},
+		{"a", "application/zip", "", true},
+		{"a.tar.GZ", "", "", true},
+		{"a.json", "application/json", "", false},
+	}
+
+	for _, test := range tests {
+		if got := Compressed(test.key, test.contentType, test.contentEncoding); got != test.want {
+			t.Errorf("Compressed(%q, %q, %q) = %t, want %t", test.key, test.contentType, test.contentEncoding, got, test.want)
+		}
+	}
+}
@@ -10,36 +10,115 @@ type Config struct {
 	MsgTimeout                string `fig:"msgTimeout" default:"30m"`
 	ShutdownWait              string `fig:"shutdownWait" default:"0s"`
 	SkipEventBucketValidation bool   `fig:"skipEventBucketValidation"`
+	SkipIdentical             bool   `fig:"skipIdentical"`
 	SkipLifecycleExpired      bool   `fig:"skipLifecycleExpired"`
 	WaitForMatchingETag       bool   `fig:"waitForMatchingETag"`
+	Workers                   int    `fig:"workers" default:"1"`
 
 	Src struct {
 		AccessKey         string `fig:"accessKey"`
+		AzureAccountKey   string `fig:"azureAccountKey"`
+		AzureAccountName  string `fig:"azureAccountName"`
+		AzureConnection   string `fig:"azureConnectionString"`
 		Bucket            string `fig:"bucket"`
+		BucketLookup      string `fig:"bucketLookup" default:"auto"`
 		Endpoint          string `fig:"endpoint"`
 		GoogleCredentials string `fig:"googleCredentials"`
 		Name              string `fig:"name" default:"destination"`
+		Region            string `fig:"region"`
+		RoleARN           string `fig:"roleARN"`
+		RoleSessionName   string `fig:"roleSessionName"`
 		SecretKey         string `fig:"secretKey"`
+		SessionToken      string `fig:"sessionToken"`
+		STSEndpoint       string `fig:"stsEndpoint"`
+		Type              string `fig:"type"`
 		UseSSL            bool   `fig:"useSSL"`
 	}
 
-	Dest struct {
-		AccessKey         string `fig:"accessKey"`
-		Bucket            string `fig:"bucket"`
-		Endpoint          string `fig:"endpoint"`
-		GoogleCredentials string `fig:"googleCredentials"`
-		Name              string `fig:"name" default:"source"`
-		PartSize          uint64 `fig:"partSize" default:"16"`
-		SecretKey         string `fig:"secretKey"`
-		Threads           uint   `fig:"threads" default:"4"`
-		UseSSL            bool   `fig:"useSSL"`
-	}
+	// single destination, superseded by destinations
+	Dest DestConfig
+
+	Destinations []DestConfig `fig:"destinations"`
 
 	ExcludePaths struct {
 		CopyObject   []string `fig:"copyObject"`
 		RemoveObject []string `fig:"removeObject"`
 	}
 
+	IncludePaths struct {
+		CopyObject   []string `fig:"copyObject"`
+		RemoveObject []string `fig:"removeObject"`
+	}
+
+	// only apply to copy events, a delete event doesn't carry the object's size or content type
+	Filters struct {
+		ContentTypes []string `fig:"contentTypes"`
+		MaxSize      int64    `fig:"maxSize"`
+		MinSize      int64    `fig:"minSize"`
+	}
+
+	// applied in order to every key on its way to the destinations
+	KeyRules []KeyRuleConfig `fig:"keyRules"`
+
+	// the first route matching a key is applied, the default route to the rest
+	DefaultRoute RouteConfig   `fig:"defaultRoute"`
+	Routes       []RouteConfig `fig:"routes"`
+
+	// every applied copy and delete is recorded for the snapshots
+	Manifest struct {
+		Destination   string `fig:"destination"`
+		Enabled       bool   `fig:"enabled"`
+		FlushInterval string `fig:"flushInterval" default:"1m"`
+		MaxEntries    int    `fig:"maxEntries" default:"10000"`
+		Prefix        string `fig:"prefix" default:".archie-manifest/"`
+	}
+
+	DeleteBreaker struct {
+		AdminPort         int     `fig:"adminPort" default:"9998"`
+		AdminToken        string  `fig:"adminToken"`
+		Enabled           bool    `fig:"enabled"`
+		MaxDeletes        int     `fig:"maxDeletes" default:"1000"`
+		MaxPercent        float64 `fig:"maxPercent"`
+		PercentMinDeletes int     `fig:"percentMinDeletes" default:"10"`
+		PrefixDepth       int     `fig:"prefixDepth" default:"1"`
+		Window            string  `fig:"window" default:"1m"`
+	}
+
+	DeletePolicy struct {
+		Delay           string `fig:"delay" default:"24h"`
+		Mode            string `fig:"mode" default:"mirror"`
+		TombstoneExpiry string `fig:"tombstoneExpiry" default:"720h"`
+		TombstonePrefix string `fig:"tombstonePrefix" default:".archie-tombstones/"`
+	}
+
+	// destination objects are encrypted when a key file is set
+	Encryption struct {
+		KeyFile          string   `fig:"keyFile"`
+		PreviousKeyFiles []string `fig:"previousKeyFiles"`
+	}
+
+	Checksum struct {
+		Algorithm string `fig:"algorithm"`
+		ReadBack  bool   `fig:"readBack"`
+		Record    bool   `fig:"record"`
+	}
+
+	Metadata struct {
+		Allow    []string `fig:"allow"`
+		Deny     []string `fig:"deny"`
+		Disabled bool     `fig:"disabled"`
+		Tags     bool     `fig:"tags"`
+	}
+
+	Reconcile struct {
+		Enabled       bool   `fig:"enabled"`
+		Interval      string `fig:"interval" default:"24h"`
+		MaxReportKeys int    `fig:"maxReportKeys" default:"1000"`
+		Prefix        string `fig:"prefix"`
+		Repair        bool   `fig:"repair"`
+		RepairExtra   bool   `fig:"repairExtra"`
+	}
+
 	HealthCheck struct {
 		Disabled bool
 		Port     int `default:"8080"`
@@ -67,9 +146,90 @@ type Config struct {
 			Retention        string `fig:"retention" default:"limits"`
 		}
 
+		CheckpointBucket string `fig:"checkpointBucket" default:"archie-checkpoints"`
+		KeyIndexBucket   string `fig:"keyIndexBucket" default:"archie-key-index"`
+		ProgressBucket   string `fig:"progressBucket" default:"archie-progress"`
+
+		DeadLetter struct {
+			MaxAge  string `fig:"maxAge"`
+			Stream  string `fig:"stream" default:"archie-dead-letter"`
+			Subject string `fig:"subject"`
+		}
+
 		Consumer struct {
 			Name          string `fig:"name" default:"archie-consumer"`
 			MaxAckPending int    `fig:"maxAckPending" default:"1000"`
 		}
 	}
 }
+
+type DestConfig struct {
+	AccessKey         string `fig:"accessKey"`
+	AzureAccountKey   string `fig:"azureAccountKey"`
+	AzureAccountName  string `fig:"azureAccountName"`
+	AzureConnection   string `fig:"azureConnectionString"`
+	Bucket            string `fig:"bucket"`
+	BucketLookup      string `fig:"bucketLookup" default:"auto"`
+	Endpoint          string `fig:"endpoint"`
+	GoogleCredentials string `fig:"googleCredentials"`
+	Name              string `fig:"name" default:"source"`
+	PartSize          uint64 `fig:"partSize" default:"16"`
+	Region            string `fig:"region"`
+	RoleARN           string `fig:"roleARN"`
+	RoleSessionName   string `fig:"roleSessionName"`
+	SecretKey         string `fig:"secretKey"`
+	SessionToken      string `fig:"sessionToken"`
+	STSEndpoint       string `fig:"stsEndpoint"`
+	Threads           uint   `fig:"threads" default:"4"`
+	Type              string `fig:"type"`
+	UseSSL            bool   `fig:"useSSL"`
+
+	// copy without streaming when the destination shares the source's backend and endpoint
+	ServerSideCopyDisabled bool `fig:"serverSideCopyDisabled"`
+}
+
+type KeyRuleConfig struct {
+	AddPrefix   string `fig:"addPrefix"`
+	DatePrefix  string `fig:"datePrefix"`
+	Pattern     string `fig:"pattern"`
+	Replace     string `fig:"replace"`
+	StripPrefix string `fig:"stripPrefix"`
+}
+
+type RouteConfig struct {
+	Compression       string   `fig:"compression"`
+	CompressionSuffix bool     `fig:"compressionSuffix"`
+	CopyOnly          bool     `fig:"copyOnly"`
+	DeleteOnly        bool     `fig:"deleteOnly"`
+	Destinations      []string `fig:"destinations"`
+	LegalHold         bool     `fig:"legalHold"`
+	Name              string   `fig:"name"`
+	Pattern           string   `fig:"pattern"`
+	RetentionMode     string   `fig:"retentionMode"`
+	RetentionPeriod   string   `fig:"retentionPeriod"`
+	StorageClass      string   `fig:"storageClass"`
+	// storage class by destination name, over storageClass
+	StorageClasses map[string]string `fig:"storageClasses"`
+}
+
+func (d DestConfig) redacted() DestConfig {
+	if d.AccessKey != "" {
+		d.AccessKey = "REDACTED"
+	}
+	if d.SecretKey != "" {
+		d.SecretKey = "REDACTED"
+	}
+	if d.SessionToken != "" {
+		d.SessionToken = "REDACTED"
+	}
+	if d.GoogleCredentials != "" {
+		d.GoogleCredentials = "REDACTED"
+	}
+	if d.AzureAccountKey != "" {
+		d.AzureAccountKey = "REDACTED"
+	}
+	if d.AzureConnection != "" {
+		d.AzureConnection = "REDACTED"
+	}
+	return d
+}
@@ -0,0 +1,224 @@
+package encryption
+
+import (
+	"archie/client"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Scheme is the only envelope format, each 64KiB chunk is sealed with aes-256-gcm under a per object data key
+const Scheme = "aes-256-gcm-64k"
+
+// the destination user metadata names the envelope is recorded under
+const (
+	DataKeyMetadata = client.EncryptionDataKeyMetadata
+	KeyIDMetadata   = client.EncryptionKeyIDMetadata
+	NonceMetadata   = client.EncryptionNonceMetadata
+	SchemeMetadata  = client.EncryptionMetadata
+)
+
+const (
+	aesTagSize  = 16
+	chunkSize   = 64 * 1024
+	dataKeySize = 32
+	// the chunk nonce is the object's random prefix and the chunk counter
+	noncePrefixSize = 8
+)
+
+var ErrCorrupt = errors.New("the encrypted object is corrupt, truncated or was encrypted with another key")
+
+// KeyProvider wraps the per object data keys, KeyID names the key new data keys are wrapped with
+// and UnwrapKey gets the id the data key was wrapped with so older keys can still be unwrapped
+type KeyProvider interface {
+	KeyID() string
+	UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error)
+	WrapKey(dataKey []byte) ([]byte, error)
+}
+
+type Encryptor struct {
+	Provider KeyProvider
+}
+
+// Encrypt the object stream under a new data key, the encrypted size and the metadata
+// needed to decrypt it are returned with the reader
+func (e *Encryptor) Encrypt(reader io.Reader, size int64) (io.Reader, int64, map[string]string, error) {
+	dataKey := make([]byte, dataKeySize)
+	noncePrefix := make([]byte, noncePrefixSize)
+	for _, random := range [][]byte{dataKey, noncePrefix} {
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	wrappedKey, err := e.Provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to wrap the data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	metadata := map[string]string{
+		DataKeyMetadata: base64.StdEncoding.EncodeToString(wrappedKey),
+		KeyIDMetadata:   e.Provider.KeyID(),
+		NonceMetadata:   base64.StdEncoding.EncodeToString(noncePrefix),
+		SchemeMetadata:  Scheme,
+	}
+
+	return &chunkReader{aead: aead, noncePrefix: noncePrefix, reader: reader, seal: true}, EncryptedSize(size), metadata, nil
+}
+
+// Decrypt an object stream with the metadata recorded when it was encrypted
+func (e *Encryptor) Decrypt(reader io.Reader, metadata map[string]string) (io.Reader, error) {
+	scheme := client.MetadataValue(metadata, SchemeMetadata)
+	if scheme != Scheme {
+		return nil, fmt.Errorf("unknown encryption scheme %q", scheme)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(client.MetadataValue(metadata, DataKeyMetadata))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the wrapped data key: %w", err)
+	}
+	noncePrefix, err := base64.StdEncoding.DecodeString(client.MetadataValue(metadata, NonceMetadata))
+	if err != nil || len(noncePrefix) != noncePrefixSize {
+		return nil, fmt.Errorf("failed to decode the nonce prefix")
+	}
+
+	dataKey, err := e.Provider.UnwrapKey(client.MetadataValue(metadata, KeyIDMetadata), wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{aead: aead, noncePrefix: noncePrefix, reader: reader}, nil
+}
+
+// IsEncrypted reports if the object metadata has an encryption envelope
+func IsEncrypted(metadata map[string]string) bool {
+	return client.MetadataValue(metadata, SchemeMetadata) != ""
+}
+
+// EncryptedSize of an object, every chunk gains a tag and an empty object is a single empty chunk,
+// an unknown size stays unknown
+func EncryptedSize(size int64) int64 {
+	if size < 0 {
+		return size
+	}
+	chunks := size / chunkSize
+	if size%chunkSize != 0 || size == 0 {
+		chunks++
+	}
+	return size + chunks*aesTagSize
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkReader seals or opens the stream a chunk at a time, the last chunk is sealed with
+// different additional data so a truncated stream doesn't open
+type chunkReader struct {
+	aead        cipher.AEAD
+	counter     uint32
+	done        bool
+	noncePrefix []byte
+	out         []byte
+	peeked      []byte
+	reader      io.Reader
+	seal        bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		err := c.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func (c *chunkReader) next() error {
+	size := chunkSize
+	if !c.seal {
+		size += aesTagSize
+	}
+
+	chunk := make([]byte, size)
+	n := copy(chunk, c.peeked)
+	c.peeked = nil
+
+	read, err := io.ReadFull(c.reader, chunk[n:])
+	n += read
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	} else {
+		// a full chunk is only the last one when nothing follows it
+		peek := make([]byte, 1)
+		read, err = io.ReadFull(c.reader, peek)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		} else {
+			c.peeked = peek[:read]
+		}
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, c.noncePrefix)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], c.counter)
+	c.counter++
+
+	additionalData := []byte{0}
+	if last {
+		additionalData[0] = 1
+	}
+
+	if c.seal {
+		c.out = c.aead.Seal(nil, nonce, chunk[:n], additionalData)
+	} else {
+		c.out, err = c.aead.Open(nil, nonce, chunk[:n], additionalData)
+		if err != nil {
+			return ErrCorrupt
+		}
+
+		// nothing may follow the final chunk, even from a reader that goes on after its first EOF
+		if last {
+			read, _ = c.reader.Read(make([]byte, 1))
+			if read > 0 {
+				c.out = nil
+				return ErrCorrupt
+			}
+		}
+	}
+
+	c.done = last
+	return nil
+}
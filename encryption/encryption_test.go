@@ -0,0 +1,224 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// an encryptor with a new random key file
+func testEncryptor(t *testing.T) *Encryptor {
+	t.Helper()
+
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFiles, err := NewKeyFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Encryptor{Provider: keyFiles}
+}
+
+func encrypt(t *testing.T, e *Encryptor, plaintext []byte) ([]byte, map[string]string) {
+	t.Helper()
+
+	reader, size, metadata, err := e.Encrypt(bytes.NewReader(plaintext), int64(len(plaintext)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(ciphertext)) != size {
+		t.Fatalf("encrypted %d bytes, EncryptedSize reported %d", len(ciphertext), size)
+	}
+	return ciphertext, metadata
+}
+
+func decrypt(e *Encryptor, reader io.Reader, metadata map[string]string) ([]byte, error) {
+	decrypted, err := e.Decrypt(reader, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decrypted)
+}
+
+func TestRoundTrip(t *testing.T) {
+	e := testEncryptor(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"exactly one chunk", chunkSize},
+		{"one chunk minus one", chunkSize - 1},
+		{"one chunk plus one", chunkSize + 1},
+		{"two chunks", 2 * chunkSize},
+		{"two chunks minus one", 2*chunkSize - 1},
+		{"two chunks plus one", 2*chunkSize + 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext := make([]byte, test.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatal(err)
+			}
+
+			ciphertext, metadata := encrypt(t, e, plaintext)
+			if !IsEncrypted(metadata) {
+				t.Error("the metadata has no encryption envelope")
+			}
+
+			decrypted, err := decrypt(e, bytes.NewReader(ciphertext), metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Error("the decrypted object differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestUnknownSize(t *testing.T) {
+	e := testEncryptor(t)
+	plaintext := bytes.Repeat([]byte("a"), chunkSize+10)
+
+	reader, size, metadata, err := e.Encrypt(bytes.NewReader(plaintext), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != -1 {
+		t.Errorf("an unknown size encrypted to %d", size)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(ciphertext)) != EncryptedSize(int64(len(plaintext))) {
+		t.Errorf("encrypted %d bytes, want %d", len(ciphertext), EncryptedSize(int64(len(plaintext))))
+	}
+
+	decrypted, err := decrypt(e, bytes.NewReader(ciphertext), metadata)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypt = %v, the plaintext matches: %t", err, bytes.Equal(decrypted, plaintext))
+	}
+}
+
+// a reader that has more to read after its first EOF
+type resumingReader struct {
+	first io.Reader
+	rest  io.Reader
+	eof   bool
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	if !r.eof {
+		n, err := r.first.Read(p)
+		if err == io.EOF {
+			r.eof = true
+		}
+		return n, err
+	}
+	return r.rest.Read(p)
+}
+
+func TestCorrupt(t *testing.T) {
+	e := testEncryptor(t)
+
+	plaintext := make([]byte, 2*chunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, metadata := encrypt(t, e, plaintext)
+	sealedChunk := chunkSize + aesTagSize
+
+	fullChunks := make([]byte, 2*chunkSize)
+	if _, err := rand.Read(fullChunks); err != nil {
+		t.Fatal(err)
+	}
+	fullCiphertext, fullMetadata := encrypt(t, e, fullChunks)
+
+	swapped := append([]byte{}, ciphertext[sealedChunk:2*sealedChunk]...)
+	swapped = append(swapped, ciphertext[:sealedChunk]...)
+	swapped = append(swapped, ciphertext[2*sealedChunk:]...)
+
+	flipped := append([]byte{}, ciphertext...)
+	flipped[10] ^= 1
+
+	tests := []struct {
+		name     string
+		reader   io.Reader
+		metadata map[string]string
+	}{
+		{"truncated last chunk", bytes.NewReader(ciphertext[:len(ciphertext)-1]), metadata},
+		{"dropped last chunk", bytes.NewReader(ciphertext[:2*sealedChunk]), metadata},
+		{"dropped last full chunk", bytes.NewReader(fullCiphertext[:sealedChunk]), fullMetadata},
+		{"reordered chunks", bytes.NewReader(swapped), metadata},
+		{"flipped bit", bytes.NewReader(flipped), metadata},
+		{"trailing bytes", bytes.NewReader(append(append([]byte{}, ciphertext...), 0)), metadata},
+		{"trailing bytes after a full chunk", bytes.NewReader(append(append([]byte{}, fullCiphertext...), 0)), fullMetadata},
+		{"trailing bytes after the first eof", &resumingReader{first: bytes.NewReader(ciphertext), rest: bytes.NewReader([]byte{0})}, metadata},
+		{"empty", bytes.NewReader(nil), metadata},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decrypt(e, test.reader, test.metadata)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("decrypt = %v, want %v", err, ErrCorrupt)
+			}
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	e := testEncryptor(t)
+	ciphertext, metadata := encrypt(t, e, []byte("secret"))
+
+	// another key file has another key id
+	if _, err := decrypt(testEncryptor(t), bytes.NewReader(ciphertext), metadata); err == nil {
+		t.Error("decrypted with another key")
+	}
+
+	// the data key of another object
+	_, otherMetadata := encrypt(t, e, []byte("other"))
+	metadata[DataKeyMetadata] = otherMetadata[DataKeyMetadata]
+	if _, err := decrypt(e, bytes.NewReader(ciphertext), metadata); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("decrypt with another data key = %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestEncryptedSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{-1, -1},
+		{0, aesTagSize},
+		{1, 1 + aesTagSize},
+		{chunkSize, chunkSize + aesTagSize},
+		{chunkSize + 1, chunkSize + 1 + 2*aesTagSize},
+	}
+
+	for _, test := range tests {
+		if got := EncryptedSize(test.size); got != test.want {
+			t.Errorf("EncryptedSize(%d) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
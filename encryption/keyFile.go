@@ -0,0 +1,102 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// KeyFiles wraps the data keys with local 256-bit keys, new data keys use the first one and the
+// previous ones are kept to unwrap the objects written before a rotation
+type KeyFiles struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyFiles loads the key files, each holds a 32 byte key as raw bytes, hex or base64
+func NewKeyFiles(current string, previous ...string) (*KeyFiles, error) {
+	k := &KeyFiles{keys: map[string][]byte{}}
+
+	for i, path := range append([]string{current}, previous...) {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// the id names the key without revealing it
+		sum := sha256.Sum256(key)
+		keyID := "local:" + hex.EncodeToString(sum[:8])
+		if i == 0 {
+			k.current = keyID
+		}
+		k.keys[keyID] = key
+	}
+
+	return k, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contents) == dataKeySize {
+		return contents, nil
+	}
+
+	trimmed := bytes.TrimSpace(contents)
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && len(key) == dataKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && len(key) == dataKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("the key file %s must hold a 32 byte key as raw bytes, hex or base64", path)
+}
+
+func (k *KeyFiles) KeyID() string {
+	return k.current
+}
+
+// the wrapped key is the nonce followed by the sealed data key
+func (k *KeyFiles) WrapKey(dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(k.keys[k.current])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *KeyFiles) UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("no key file has the key id %s", keyID)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+
+	dataKey, err := aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return dataKey, nil
+}
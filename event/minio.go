@@ -46,6 +46,7 @@ type Object struct {
 	ContentType  string       `json:"contentType"`
 	UserMetadata UserMetadata `json:"userMetadata"`
 	Sequencer    string       `json:"sequencer"`
+	VersionID    string       `json:"versionId"`
 }
 
 type S3 struct {
@@ -4,8 +4,11 @@ go 1.19
 
 require (
 	cloud.google.com/go/storage v1.29.0
+	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.3.0
+	github.com/Azure/azure-sdk-for-go/sdk/storage/azblob v1.0.0
 	github.com/InVisionApp/go-health/v2 v2.1.3
 	github.com/kkyr/fig v0.3.1
+	github.com/klauspost/compress v1.15.15
 	github.com/minio/minio-go/v7 v7.0.49
 	github.com/nats-io/nats.go v1.24.0
 	github.com/prometheus/client_golang v1.14.0
@@ -26,6 +29,7 @@ require (
 
 require (
 	cloud.google.com/go v0.110.0 // indirect
+	github.com/Azure/azure-sdk-for-go/sdk/internal v1.1.1 // indirect
 	github.com/InVisionApp/go-logger v1.0.1 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
@@ -35,7 +39,6 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/googleapis/gax-go/v2 v2.7.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.15.15 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.4 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
@@ -4,29 +4,36 @@ import (
 	"archie/archie"
 	"archie/client"
 	"context"
-	"encoding/json"
 	"flag"
-	"github.com/kkyr/fig"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"go.arsenm.dev/pcre"
-	"path/filepath"
-	"sync"
+	"net/http"
+	"os"
 	"time"
 )
 
 func main() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 
+	// subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(replay(os.Args[2:]))
+		case "backfill":
+			os.Exit(backfill(os.Args[2:]))
+		case "restore":
+			os.Exit(restore(os.Args[2:]))
+		case "snapshot":
+			os.Exit(snapshot(os.Args[2:]))
+		}
+	}
+
 	configFile := flag.String("config", "config.yaml", "config file path")
 	logLevelFlag := flag.String("log-level", LookupEnvOrString("LOG_LEVEL", ""), "set the log level (default: info)")
 	flag.Parse()
 
-	var cfg Config
-	err := fig.Load(&cfg, fig.File(filepath.Base(*configFile)), fig.Dirs(".", filepath.Dir(*configFile)))
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load config file")
-	}
+	cfg := loadConfig(*configFile)
 
 	log.Info().
 		Str("version", archie.Version).
@@ -35,45 +42,10 @@ func main() {
 		Str("buildDate", archie.BuildDate).
 		Msg("Starting archie")
 
-	// compile and validate pcre regex exclude patterns
-	var excludedPathCopyObject, excludedPathRemoveObject []*pcre.Regexp
-
-	if len(cfg.ExcludePaths.CopyObject) > 0 || len(cfg.ExcludePaths.RemoveObject) > 0 {
-		for _, excludedPathPattern := range cfg.ExcludePaths.CopyObject {
-			excludedPathRegexp, err := pcre.Compile(excludedPathPattern)
-			if err != nil {
-				log.Fatal().Err(err).Str("pattern", excludedPathPattern).Msg("Failed to compile CopyObject pcre regex")
-			}
-			excludedPathCopyObject = append(excludedPathCopyObject, excludedPathRegexp)
-		}
-
-		for _, excludedPathPattern := range cfg.ExcludePaths.RemoveObject {
-			excludedPathRegexp, err := pcre.Compile(excludedPathPattern)
-			if err != nil {
-				log.Fatal().Err(err).Str("pattern", excludedPathPattern).Msg("Failed to compile RemoveObject pcre regex")
-			}
-			excludedPathRemoveObject = append(excludedPathRemoveObject, excludedPathRegexp)
-		}
-
-		log.Info().Msgf("Regex patterns compiled with pcre v%s", pcre.Version())
-	}
-
-	var logLevel string
-	// prefer cli arg over config
-	if *logLevelFlag != "" {
-		logLevel = *logLevelFlag
-	} else {
-		logLevel = cfg.LogLevel
-	}
+	// archiver
+	a := newArchiver(cfg)
 
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if logLevel == "trace" {
-		log.Info().Msg("Trace logging enabled")
-		zerolog.SetGlobalLevel(zerolog.TraceLevel)
-	} else if logLevel == "debug" {
-		log.Info().Msg("Debug logging enabled")
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	}
+	setLogLevel(*logLevelFlag, cfg.LogLevel)
 
 	// base context - cancel message processing (give time to let active transfers finish)
 	baseCtx, baseCancel := context.WithCancel(context.Background())
@@ -89,129 +61,11 @@ func main() {
 		msgCancel()
 	}()
 
-	// log all config settings
-	redactedCfg := cfg
+	logConfig(cfg)
 
-	if redactedCfg.Src.AccessKey != "" {
-		redactedCfg.Src.AccessKey = "REDACTED"
-	}
-	if redactedCfg.Src.SecretKey != "" {
-		redactedCfg.Src.SecretKey = "REDACTED"
-	}
-	if redactedCfg.Src.GoogleCredentials != "" {
-		redactedCfg.Src.GoogleCredentials = "REDACTED"
-	}
-	if redactedCfg.Dest.AccessKey != "" {
-		redactedCfg.Dest.AccessKey = "REDACTED"
-	}
-	if redactedCfg.Dest.SecretKey != "" {
-		redactedCfg.Dest.SecretKey = "REDACTED"
-	}
-	if redactedCfg.Dest.GoogleCredentials != "" {
-		redactedCfg.Dest.GoogleCredentials = "REDACTED"
-	}
-	if redactedCfg.Jetstream.Password != "" {
-		redactedCfg.Jetstream.Password = "REDACTED"
-	}
-
-	redactedCfgJSON, err := json.Marshal(redactedCfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to marshal config to json")
-	}
-
-	log.Info().RawJSON("cfg", redactedCfgJSON).Msg("Startup configuration")
-
-	// archiver
-	a := archie.Archiver{
-		BackoffDurationMultiplier: cfg.BackoffDurationMultiplier,
-		BackoffNumCeiling:         cfg.BackoffNumCeiling,
-		DestBucket:                cfg.Dest.Bucket,
-		DestName:                  cfg.Dest.Name,
-		DestPartSize:              cfg.Dest.PartSize,
-		DestThreads:               cfg.Dest.Threads,
-		FetchDone:                 make(chan string, 1),
-		HealthCheckDisabled:       cfg.HealthCheck.Disabled,
-		MaxRetries:                cfg.MaxRetries,
-		MsgTimeout:                cfg.MsgTimeout,
-		SkipEventBucketValidation: cfg.SkipEventBucketValidation,
-		SkipLifecycleExpired:      cfg.SkipLifecycleExpired,
-		SrcBucket:                 cfg.Src.Bucket,
-		SrcName:                   cfg.Src.Name,
-		WaitForMatchingETag:       cfg.WaitForMatchingETag,
-		WaitGroup:                 &sync.WaitGroup{},
-		ExcludePaths: struct {
-			CopyObject   []*pcre.Regexp
-			RemoveObject []*pcre.Regexp
-		}{
-			CopyObject:   excludedPathCopyObject,
-			RemoveObject: excludedPathRemoveObject,
-		},
-	}
-
-	var srcHealthCheckCancel, destHealthCheckCancel context.CancelFunc
-
-	// source
-	var c client.Client
-	if cfg.Src.GoogleCredentials != "" {
-		c = &client.GCS{}
-	} else {
-		c = &client.Minio{}
-	}
-
-	srcHealthCheckCancel = c.New(
-		baseCtx,
-		cfg.Src.Name,
-		cfg.Src.Bucket,
-		cfg.Src.Endpoint,
-		client.Credentials{
-			MinioSecretAccessKey: cfg.Src.SecretKey,
-			MinioAccessKey:       cfg.Src.AccessKey,
-			GoogleCredentials:    cfg.Src.GoogleCredentials,
-		},
-		cfg.Src.UseSSL,
-		client.Params{},
-		zerolog.GlobalLevel(),
-	)
-
-	a.SrcClient = c
-
-	defer func() {
-		log.Trace().Msg("Deferred source health check context canceled")
-		srcHealthCheckCancel()
-	}()
-
-	// destination
-	var d client.Client
-	if cfg.Dest.GoogleCredentials != "" {
-		d = &client.GCS{}
-	} else {
-		d = &client.Minio{}
-	}
-
-	destHealthCheckCancel = d.New(
-		baseCtx,
-		cfg.Dest.Name,
-		cfg.Dest.Bucket,
-		cfg.Dest.Endpoint,
-		client.Credentials{
-			MinioSecretAccessKey: cfg.Dest.SecretKey,
-			MinioAccessKey:       cfg.Dest.AccessKey,
-			GoogleCredentials:    cfg.Dest.GoogleCredentials,
-		},
-		cfg.Dest.UseSSL,
-		client.Params{
-			Threads:  cfg.Dest.Threads,
-			PartSize: cfg.Dest.PartSize,
-		},
-		zerolog.GlobalLevel(),
-	)
-
-	a.DestClient = d
-
-	defer func() {
-		log.Trace().Msg("Deferred destination health check context canceled")
-		destHealthCheckCancel()
-	}()
+	// source and destinations
+	healthCheckCancel := setupClients(baseCtx, cfg, a)
+	defer healthCheckCancel()
 
 	// queue
 	jetStreamSub, jetStreamConn := client.JetStream(
@@ -229,20 +83,106 @@ func main() {
 		cfg.MsgTimeout,
 		cfg.Jetstream.Stream.Retention,
 		cfg.Jetstream.Stream.RepublishSubject,
+		cfg.Jetstream.DeadLetter.Subject,
+		cfg.Jetstream.DeadLetter.Stream,
+		cfg.Jetstream.DeadLetter.MaxAge,
 		cfg.Jetstream.ProvisioningDisabled,
 	)
 
+	if cfg.Jetstream.DeadLetter.Subject != "" {
+		var err error
+		a.DeadLetterSubject = cfg.Jetstream.DeadLetter.Subject
+		a.JetStream, err = jetStreamConn.JetStream()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize JetStream context")
+		}
+	}
+
+	// the date partitioned destination keys for the deletes
+	setupKeyIndex(cfg, jetStreamConn, a)
+
+	// track which destinations finished each message so a retry skips them
+	if len(a.Destinations) > 1 {
+		a.ProgressKV = client.KeyValue(
+			jetStreamConn,
+			cfg.Jetstream.ProgressBucket,
+			cfg.Jetstream.Stream.MaxAge,
+			cfg.Jetstream.Stream.Replicas,
+			cfg.Jetstream.ProvisioningDisabled,
+		)
+	}
+
+	// the breaker's open state is shared by the replicas
+	var adminSrv *http.Server
+	if a.DeleteBreaker != nil {
+		a.DeleteBreaker.StateKV = client.KeyValue(
+			jetStreamConn,
+			cfg.Jetstream.CheckpointBucket,
+			"",
+			cfg.Jetstream.Stream.Replicas,
+			cfg.Jetstream.ProvisioningDisabled,
+		)
+		adminSrv = a.StartDeleteBreakerAPI()
+	}
+
 	// health check server
 	healthCheckSrv := a.StartHealthCheckServer(cfg.HealthCheck.Port, jetStreamConn)
 
 	// metrics server
 	metricsSrv := a.StartMetricsServer(cfg.Metrics.Port)
 
-	// single-thread message processor
+	// reconciler
+	if cfg.Reconcile.Enabled {
+		reconcileInterval, err := time.ParseDuration(cfg.Reconcile.Interval)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse reconcile interval duration argument")
+		}
+
+		if cfg.Reconcile.Repair && a.JetStream == nil {
+			a.JetStream, err = jetStreamConn.JetStream()
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to initialize JetStream context")
+			}
+		}
+
+		a.StartReconciler(baseCtx, archie.ReconcileOptions{
+			Interval: reconcileInterval,
+			LockKV: client.KeyValue(
+				jetStreamConn,
+				cfg.Jetstream.CheckpointBucket,
+				"",
+				cfg.Jetstream.Stream.Replicas,
+				cfg.Jetstream.ProvisioningDisabled,
+			),
+			MaxReportKeys: cfg.Reconcile.MaxReportKeys,
+			Prefix:        cfg.Reconcile.Prefix,
+			Repair:        cfg.Reconcile.Repair,
+			RepairExtra:   cfg.Reconcile.RepairExtra,
+			Subject:       cfg.Jetstream.Subject,
+		})
+	}
+
+	// tombstones are kept until their expiry, a zero expiry keeps them
+	if a.DeletePolicy.Mode == archie.DeletePolicyTombstone && a.DeletePolicy.TombstoneExpiry > 0 {
+		a.StartTombstonePurger(baseCtx)
+	}
+
+	// the manifest is flushed periodically and once more after the shutdown
+	if a.Manifest != nil {
+		a.StartManifestWriter(baseCtx)
+	}
+
+	// message processor with a pool of workers
 	go a.MessageProcessor(baseCtx, msgCtx, jetStreamSub, cfg.Jetstream.BatchSize)
 
 	// shutdown manager
-	a.WaitForSignal(cfg.ShutdownWait, baseCancel, msgCancel, healthCheckSrv, metricsSrv)
+	a.WaitForSignal(cfg.ShutdownWait, baseCancel, msgCancel, healthCheckSrv, metricsSrv, adminSrv)
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer flushCancel()
+	if err := a.FlushManifest(flushCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to flush the manifest on shutdown")
+	}
 
 	log.Info().Msg("Shutdown complete")
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archie/archie"
+	"archie/client"
+	"context"
+	"flag"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// replay re-drives the messages of a stream, like the dead letter stream,
+// by republishing them to the archie subject or processing them directly
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "config file path")
+	logLevelFlag := flags.String("log-level", LookupEnvOrString("LOG_LEVEL", ""), "set the log level (default: info)")
+	stream := flags.String("stream", "", "stream to replay (default: jetstream.stream.name)")
+	deadLetter := flags.Bool("dead-letter", false, "replay the jetstream.deadLetter.stream")
+	filterSubject := flags.String("filter-subject", "", "only replay the messages on this subject")
+	startSeq := flags.Uint64("start-seq", 0, "first stream sequence to replay")
+	endSeq := flags.Uint64("end-seq", 0, "last stream sequence to replay (default: last sequence when the replay started)")
+	startTimeFlag := flags.String("start-time", "", "replay the messages stored since this RFC3339 time")
+	endTimeFlag := flags.String("end-time", "", "replay the messages stored up to this RFC3339 time")
+	mode := flags.String("mode", "process", "\"process\" the messages directly or \"republish\" them to jetstream.subject")
+	dryRun := flags.Bool("dry-run", false, "only report what would be copied, deleted or republished")
+	batchSize := flags.Int("batch-size", 10, "number of messages to fetch with each pull")
+	_ = flags.Parse(args)
+
+	cfg := loadConfig(*configFile)
+	setLogLevel(*logLevelFlag, cfg.LogLevel)
+
+	if *mode != "process" && *mode != "republish" {
+		log.Fatal().Msgf("Unknown replay mode %s, must be one of: process, republish", *mode)
+	}
+	if *startSeq > 0 && *startTimeFlag != "" {
+		log.Fatal().Msg("Use either -start-seq or -start-time, not both")
+	}
+
+	replayStream := *stream
+	if *deadLetter {
+		replayStream = cfg.Jetstream.DeadLetter.Stream
+	} else if replayStream == "" {
+		replayStream = cfg.Jetstream.Stream.Name
+	}
+
+	var endTime time.Time
+	if *endTimeFlag != "" {
+		var err error
+		endTime, err = time.Parse(time.RFC3339, *endTimeFlag)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse the -end-time argument")
+		}
+	}
+
+	// stop cleanly on ctrl-c, the current message is finished first
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logConfig(cfg)
+
+	a := newArchiver(cfg)
+	a.DryRun = *dryRun
+
+	if *mode == "process" {
+		healthCheckCancel := setupClients(ctx, cfg, a)
+		defer healthCheckCancel()
+	}
+
+	natsClient := client.Connect(cfg.Jetstream.URL, cfg.Jetstream.RootCA, cfg.Jetstream.Username, cfg.Jetstream.Password)
+	defer natsClient.Close()
+
+	jetStream, err := natsClient.JetStream()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize JetStream context")
+	}
+	a.JetStream = jetStream
+
+	// processed messages are only dead lettered again for a real run
+	if *mode == "process" && !*dryRun {
+		a.DeadLetterSubject = cfg.Jetstream.DeadLetter.Subject
+	}
+
+	streamInfo, err := jetStream.StreamInfo(replayStream)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to get JetStream stream %s info", replayStream)
+	}
+
+	// stop at the current end, republished messages could land in the same stream
+	replayOpts := archie.ReplayOptions{
+		BatchSize:   *batchSize,
+		EndSequence: *endSeq,
+		EndTime:     endTime,
+	}
+	if replayOpts.EndSequence == 0 {
+		replayOpts.EndSequence = streamInfo.State.LastSeq
+	}
+	if *mode == "republish" {
+		replayOpts.RepublishSubject = cfg.Jetstream.Subject
+	}
+
+	// an ephemeral consumer that delivers each message once
+	subOpts := []nats.SubOpt{
+		nats.BindStream(replayStream),
+		nats.AckExplicit(),
+		nats.MaxDeliver(1),
+	}
+
+	if *startSeq > 0 {
+		subOpts = append(subOpts, nats.StartSequence(*startSeq))
+	} else if *startTimeFlag != "" {
+		startTime, err := time.Parse(time.RFC3339, *startTimeFlag)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse the -start-time argument")
+		}
+		subOpts = append(subOpts, nats.StartTime(startTime))
+	} else {
+		subOpts = append(subOpts, nats.DeliverAll())
+	}
+
+	if msgTimeout, err := time.ParseDuration(cfg.MsgTimeout); err == nil {
+		subOpts = append(subOpts, nats.AckWait(msgTimeout))
+	}
+
+	sub, err := jetStream.PullSubscribe(*filterSubject, "", subOpts...)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to subscribe to the JetStream stream %s", replayStream)
+	}
+	defer func() {
+		// removes the ephemeral consumer
+		_ = sub.Unsubscribe()
+	}()
+
+	log.Info().
+		Str("stream", replayStream).
+		Str("filterSubject", *filterSubject).
+		Str("mode", *mode).
+		Bool("dryRun", *dryRun).
+		Uint64("endSeq", replayOpts.EndSequence).
+		Msg("Replay started")
+
+	result, err := a.Replay(ctx, sub, replayOpts)
+
+	// the processed messages are recorded even when the replay stopped
+	if flushErr := a.FlushManifest(context.Background()); flushErr != nil {
+		log.Error().Err(flushErr).Msg("Failed to flush the manifest")
+	}
+
+	if err != nil {
+		log.Error().Err(err).Interface("result", result).Msg("Replay stopped")
+		return 1
+	}
+
+	// the failed messages are left in the stream to be replayed again
+	if result.Failed > 0 {
+		log.Error().Interface("result", result).Msg("Replay complete with failed messages")
+		return 1
+	}
+
+	log.Info().Interface("result", result).Msg("Replay complete")
+	return 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archie/archie"
+	"archie/client"
+	"bufio"
+	"context"
+	"flag"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// restore copies the archived objects of a destination back to the source bucket or another destination
+func restore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "config file path")
+	logLevelFlag := flags.String("log-level", LookupEnvOrString("LOG_LEVEL", ""), "set the log level (default: info)")
+	from := flags.String("from", "", "destination to restore from (default: the first destination)")
+	to := flags.String("to", "", "destination to restore to (default: the source)")
+	bucket := flags.String("bucket", "", "bucket to restore to (default: the bucket of -to)")
+	prefix := flags.String("prefix", "", "only restore the source keys with this prefix")
+	keysFile := flags.String("keys", "", "file with the source keys to restore, one per line, - for stdin")
+	sinceFlag := flags.String("since", "", "restore the objects archived since this RFC3339 time")
+	untilFlag := flags.String("until", "", "restore the objects archived up to this RFC3339 time")
+	overwrite := flags.Bool("overwrite", false, "replace the objects that already exist in the target")
+	dryRun := flags.Bool("dry-run", false, "only report what would be restored")
+	workers := flags.Int("workers", 0, "number of objects to restore concurrently (default: workers)")
+	_ = flags.Parse(args)
+
+	cfg := loadConfig(*configFile)
+	setLogLevel(*logLevelFlag, cfg.LogLevel)
+
+	if *prefix != "" && *keysFile != "" {
+		log.Fatal().Msg("Use either -prefix or -keys, not both")
+	}
+	if *prefix == "" && *keysFile == "" && *sinceFlag == "" && *untilFlag == "" {
+		log.Fatal().Msg("Restore needs a -prefix, -keys or a -since and -until window")
+	}
+
+	restoreOpts := archie.RestoreOptions{
+		Overwrite: *overwrite,
+		Prefix:    *prefix,
+		Since:     parseRestoreTime("since", *sinceFlag),
+		Until:     parseRestoreTime("until", *untilFlag),
+	}
+
+	if *keysFile != "" {
+		restoreOpts.Keys = readRestoreKeys(*keysFile)
+	}
+
+	// stop cleanly on ctrl-c, the objects being restored are interrupted
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logConfig(cfg)
+
+	a := newArchiver(cfg)
+	a.DryRun = *dryRun
+	if *workers > 0 {
+		a.Workers = *workers
+	}
+
+	healthCheckCancel := setupClients(ctx, cfg, a)
+	defer healthCheckCancel()
+
+	// the date partitioned destination keys of the key list are looked up in the key index
+	if len(restoreOpts.Keys) > 0 && datePartitioned(cfg) {
+		natsClient := client.Connect(cfg.Jetstream.URL, cfg.Jetstream.RootCA, cfg.Jetstream.Username, cfg.Jetstream.Password)
+		defer natsClient.Close()
+		setupKeyIndex(cfg, natsClient, a)
+	}
+
+	source := a.Destinations[0]
+	if *from != "" {
+		source = findDestination(a, *from)
+	}
+
+	restoreOpts.Target = a.SrcClient
+	restoreOpts.TargetBucket = a.SrcBucket
+	if *to != "" {
+		target := findDestination(a, *to)
+		restoreOpts.Target = target.Client
+		restoreOpts.TargetBucket = target.Bucket
+	}
+	if *bucket != "" {
+		restoreOpts.TargetBucket = *bucket
+	}
+
+	log.Info().
+		Str("from", source.Name).
+		Str("bucket", restoreOpts.TargetBucket).
+		Str("prefix", *prefix).
+		Int("keys", len(restoreOpts.Keys)).
+		Bool("dryRun", *dryRun).
+		Msg("Restore started")
+
+	result, err := a.Restore(ctx, source, restoreOpts)
+	if err != nil {
+		log.Error().Err(err).Interface("result", result).Msg("Restore stopped")
+		return 1
+	}
+
+	log.Info().Interface("result", result).Msg("Restore complete")
+	if result.Failed > 0 || result.Missing > 0 {
+		return 1
+	}
+	return 0
+}
+
+func findDestination(a *archie.Archiver, name string) *archie.Destination {
+	for _, dest := range a.Destinations {
+		if dest.Name == name {
+			return dest
+		}
+	}
+	log.Fatal().Msgf("Destination %s is not configured", name)
+	return nil
+}
+
+func parseRestoreTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to parse the -%s argument", name)
+	}
+	return parsed
+}
+
+func readRestoreKeys(path string) []string {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open the -keys file")
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		// the keys can have spaces of their own
+		if key := strings.TrimSuffix(scanner.Text(), "\r"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to read the -keys file")
+	}
+	return keys
+}
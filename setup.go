@@ -0,0 +1,686 @@
+package main
+
+import (
+	"archie/archie"
+	"archie/client"
+	"archie/compression"
+	"archie/encryption"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kkyr/fig"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.arsenm.dev/pcre"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func loadConfig(configFile string) Config {
+	var cfg Config
+	err := fig.Load(&cfg, fig.File(filepath.Base(configFile)), fig.Dirs(".", filepath.Dir(configFile)))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config file")
+	}
+	return cfg
+}
+
+func setLogLevel(logLevelFlag string, cfgLogLevel string) {
+	var logLevel string
+	// prefer cli arg over config
+	if logLevelFlag != "" {
+		logLevel = logLevelFlag
+	} else {
+		logLevel = cfgLogLevel
+	}
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if logLevel == "trace" {
+		log.Info().Msg("Trace logging enabled")
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		log.Info().Msg("Debug logging enabled")
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+}
+
+// log all config settings
+func logConfig(cfg Config) {
+	redactedCfg := cfg
+
+	if redactedCfg.Src.AccessKey != "" {
+		redactedCfg.Src.AccessKey = "REDACTED"
+	}
+	if redactedCfg.Src.SecretKey != "" {
+		redactedCfg.Src.SecretKey = "REDACTED"
+	}
+	if redactedCfg.Src.SessionToken != "" {
+		redactedCfg.Src.SessionToken = "REDACTED"
+	}
+	if redactedCfg.Src.GoogleCredentials != "" {
+		redactedCfg.Src.GoogleCredentials = "REDACTED"
+	}
+	if redactedCfg.Src.AzureAccountKey != "" {
+		redactedCfg.Src.AzureAccountKey = "REDACTED"
+	}
+	if redactedCfg.Src.AzureConnection != "" {
+		redactedCfg.Src.AzureConnection = "REDACTED"
+	}
+	redactedCfg.Dest = cfg.Dest.redacted()
+	redactedCfg.Destinations = make([]DestConfig, len(cfg.Destinations))
+	for i, dest := range cfg.Destinations {
+		redactedCfg.Destinations[i] = dest.redacted()
+	}
+	if redactedCfg.DeleteBreaker.AdminToken != "" {
+		redactedCfg.DeleteBreaker.AdminToken = "REDACTED"
+	}
+	if redactedCfg.Jetstream.Password != "" {
+		redactedCfg.Jetstream.Password = "REDACTED"
+	}
+
+	redactedCfgJSON, err := json.Marshal(redactedCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to marshal config to json")
+	}
+
+	log.Info().RawJSON("cfg", redactedCfgJSON).Msg("Startup configuration")
+}
+
+func newArchiver(cfg Config) *archie.Archiver {
+	// compile and validate pcre regex exclude and include patterns
+	var excludedPathCopyObject, excludedPathRemoveObject []*pcre.Regexp
+	var includedPathCopyObject, includedPathRemoveObject []*pcre.Regexp
+
+	if len(cfg.ExcludePaths.CopyObject) > 0 || len(cfg.ExcludePaths.RemoveObject) > 0 ||
+		len(cfg.IncludePaths.CopyObject) > 0 || len(cfg.IncludePaths.RemoveObject) > 0 {
+		for _, excludedPathPattern := range cfg.ExcludePaths.CopyObject {
+			excludedPathRegexp, err := pcre.Compile(excludedPathPattern)
+			if err != nil {
+				log.Fatal().Err(err).Str("pattern", excludedPathPattern).Msg("Failed to compile CopyObject pcre regex")
+			}
+			excludedPathCopyObject = append(excludedPathCopyObject, excludedPathRegexp)
+		}
+
+		for _, excludedPathPattern := range cfg.ExcludePaths.RemoveObject {
+			excludedPathRegexp, err := pcre.Compile(excludedPathPattern)
+			if err != nil {
+				log.Fatal().Err(err).Str("pattern", excludedPathPattern).Msg("Failed to compile RemoveObject pcre regex")
+			}
+			excludedPathRemoveObject = append(excludedPathRemoveObject, excludedPathRegexp)
+		}
+
+		for _, includedPathPattern := range cfg.IncludePaths.CopyObject {
+			includedPathRegexp, err := pcre.Compile(includedPathPattern)
+			if err != nil {
+				log.Fatal().Err(err).Str("pattern", includedPathPattern).Msg("Failed to compile CopyObject include pcre regex")
+			}
+			includedPathCopyObject = append(includedPathCopyObject, includedPathRegexp)
+		}
+
+		for _, includedPathPattern := range cfg.IncludePaths.RemoveObject {
+			includedPathRegexp, err := pcre.Compile(includedPathPattern)
+			if err != nil {
+				log.Fatal().Err(err).Str("pattern", includedPathPattern).Msg("Failed to compile RemoveObject include pcre regex")
+			}
+			includedPathRemoveObject = append(includedPathRemoveObject, includedPathRegexp)
+		}
+
+		log.Info().Msgf("Regex patterns compiled with pcre v%s", pcre.Version())
+	}
+
+	keyRules := newKeyRules(cfg.KeyRules)
+	deletePolicy := newDeletePolicy(cfg)
+	deleteBreaker := newDeleteBreaker(cfg)
+
+	var encryptor *encryption.Encryptor
+	if cfg.Encryption.KeyFile != "" {
+		keyFiles, err := encryption.NewKeyFiles(cfg.Encryption.KeyFile, cfg.Encryption.PreviousKeyFiles...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load the encryption key files")
+		}
+		encryptor = &encryption.Encryptor{Provider: keyFiles}
+		log.Info().Str("keyId", keyFiles.KeyID()).Msg("Destination objects are encrypted")
+	}
+
+	if cfg.Filters.MaxSize > 0 && cfg.Filters.MinSize > cfg.Filters.MaxSize {
+		log.Fatal().Msg("Filters minSize is larger than maxSize")
+	}
+	for _, pattern := range cfg.Filters.ContentTypes {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			log.Fatal().Err(err).Str("pattern", pattern).Msg("Failed to parse the content type filter")
+		}
+	}
+
+	if cfg.Checksum.Algorithm != "" {
+		_, err := client.NewChecksumHash(cfg.Checksum.Algorithm)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to setup the checksum verification")
+		}
+	}
+
+	// validate the metadata glob patterns
+	for _, pattern := range append(append([]string{}, cfg.Metadata.Allow...), cfg.Metadata.Deny...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			log.Fatal().Err(err).Str("pattern", pattern).Msg("Failed to parse the metadata pattern")
+		}
+	}
+
+	return &archie.Archiver{
+		BackoffDurationMultiplier: cfg.BackoffDurationMultiplier,
+		BackoffNumCeiling:         cfg.BackoffNumCeiling,
+		ChecksumAlgorithm:         cfg.Checksum.Algorithm,
+		ChecksumReadBack:          cfg.Checksum.ReadBack,
+		ChecksumRecord:            cfg.Checksum.Record,
+		DeleteBreaker:             deleteBreaker,
+		DeletePolicy:              deletePolicy,
+		Encryption:                encryptor,
+		FetchDone:                 make(chan string, 1),
+		Filters: archie.ObjectFilters{
+			ContentTypes: cfg.Filters.ContentTypes,
+			MaxSize:      cfg.Filters.MaxSize,
+			MinSize:      cfg.Filters.MinSize,
+		},
+		HealthCheckDisabled: cfg.HealthCheck.Disabled,
+		KeyRules:            keyRules,
+		MaxRetries:          cfg.MaxRetries,
+		Metadata: archie.MetadataOptions{
+			Allow:    cfg.Metadata.Allow,
+			Deny:     cfg.Metadata.Deny,
+			Disabled: cfg.Metadata.Disabled,
+			Tags:     cfg.Metadata.Tags,
+		},
+		MsgTimeout:                cfg.MsgTimeout,
+		SkipEventBucketValidation: cfg.SkipEventBucketValidation,
+		SkipIdentical:             cfg.SkipIdentical,
+		SkipLifecycleExpired:      cfg.SkipLifecycleExpired,
+		SrcBucket:                 cfg.Src.Bucket,
+		SrcName:                   cfg.Src.Name,
+		WaitForMatchingETag:       cfg.WaitForMatchingETag,
+		WaitGroup:                 &sync.WaitGroup{},
+		Workers:                   cfg.Workers,
+		ExcludePaths: struct {
+			CopyObject   []*pcre.Regexp
+			RemoveObject []*pcre.Regexp
+		}{
+			CopyObject:   excludedPathCopyObject,
+			RemoveObject: excludedPathRemoveObject,
+		},
+		IncludePaths: struct {
+			CopyObject   []*pcre.Regexp
+			RemoveObject []*pcre.Regexp
+		}{
+			CopyObject:   includedPathCopyObject,
+			RemoveObject: includedPathRemoveObject,
+		},
+	}
+}
+
+// the manifest is written to the first destination unless another is named
+func newManifest(cfg Config, destinations []*archie.Destination) *archie.Manifest {
+	if !cfg.Manifest.Enabled {
+		return nil
+	}
+
+	flushInterval, err := time.ParseDuration(cfg.Manifest.FlushInterval)
+	if err != nil || flushInterval <= 0 {
+		log.Fatal().Err(err).Msg("Failed to parse manifest flush interval duration argument")
+	}
+	if cfg.Manifest.Prefix == "" {
+		log.Fatal().Msg("The manifest needs a prefix to keep it apart from the archived objects")
+	}
+
+	// each replica writes its own manifest objects
+	writer, err := os.Hostname()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to get the hostname for the manifest")
+	}
+
+	manifest := &archie.Manifest{
+		Destination:   destinations[0],
+		FlushInterval: flushInterval,
+		MaxEntries:    cfg.Manifest.MaxEntries,
+		Prefix:        cfg.Manifest.Prefix,
+		Writer:        writer,
+	}
+
+	if cfg.Manifest.Destination != "" {
+		manifest.Destination = nil
+		for _, dest := range destinations {
+			if dest.Name == cfg.Manifest.Destination {
+				manifest.Destination = dest
+			}
+		}
+		if manifest.Destination == nil {
+			log.Fatal().Msgf("Manifest destination %s is not configured", cfg.Manifest.Destination)
+		}
+	}
+
+	log.Info().
+		Str("destination", manifest.Destination.Name).
+		Str("prefix", manifest.Prefix).
+		Msg("Applied copies and deletes are recorded in the manifest")
+
+	return manifest
+}
+
+// the shared state key-value bucket is bound with the jetstream connection
+func newDeleteBreaker(cfg Config) *archie.DeleteBreaker {
+	if !cfg.DeleteBreaker.Enabled {
+		return nil
+	}
+
+	window, err := time.ParseDuration(cfg.DeleteBreaker.Window)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse delete breaker window duration argument")
+	}
+	if cfg.DeleteBreaker.MaxDeletes <= 0 && cfg.DeleteBreaker.MaxPercent <= 0 {
+		log.Fatal().Msg("Delete breaker needs maxDeletes or maxPercent")
+	}
+	// the admin api resumes the deletes so it's never left open
+	if cfg.DeleteBreaker.AdminToken == "" {
+		log.Fatal().Msg("Delete breaker needs an adminToken for its admin api")
+	}
+
+	return &archie.DeleteBreaker{
+		AdminPort:         cfg.DeleteBreaker.AdminPort,
+		AdminToken:        cfg.DeleteBreaker.AdminToken,
+		MaxDeletes:        cfg.DeleteBreaker.MaxDeletes,
+		MaxPercent:        cfg.DeleteBreaker.MaxPercent,
+		PercentMinDeletes: cfg.DeleteBreaker.PercentMinDeletes,
+		PrefixDepth:       cfg.DeleteBreaker.PrefixDepth,
+		Window:            window,
+	}
+}
+
+func newDeletePolicy(cfg Config) archie.DeletePolicy {
+	deletePolicy := archie.DeletePolicy{
+		Mode:            cfg.DeletePolicy.Mode,
+		TombstonePrefix: cfg.DeletePolicy.TombstonePrefix,
+	}
+
+	switch cfg.DeletePolicy.Mode {
+	case archie.DeletePolicyMirror, archie.DeletePolicyIgnore:
+	case archie.DeletePolicyDelay:
+		delay, err := time.ParseDuration(cfg.DeletePolicy.Delay)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse delete policy delay duration argument")
+		}
+		deletePolicy.Delay = delay
+
+		// the stream would drop the held deletes before they're due
+		if cfg.Jetstream.Stream.MaxAge != "" {
+			maxAge, err := time.ParseDuration(cfg.Jetstream.Stream.MaxAge)
+			if err == nil && maxAge > 0 && maxAge <= delay {
+				log.Warn().Msgf("The stream max age %s is shorter than the delete policy delay %s", maxAge, delay)
+			}
+		}
+	case archie.DeletePolicyTombstone:
+		if cfg.DeletePolicy.TombstonePrefix == "" {
+			log.Fatal().Msg("Delete policy tombstonePrefix is required for the tombstone mode")
+		}
+
+		expiry, err := time.ParseDuration(cfg.DeletePolicy.TombstoneExpiry)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse delete policy tombstone expiry duration argument")
+		}
+		deletePolicy.TombstoneExpiry = expiry
+	default:
+		log.Fatal().Msgf("Unknown delete policy mode %s, must be one of: mirror, ignore, delay, tombstone", cfg.DeletePolicy.Mode)
+	}
+
+	return deletePolicy
+}
+
+// compile and validate the key rules, each one sets a single rewrite
+func newKeyRules(ruleConfigs []KeyRuleConfig) []archie.KeyRule {
+	var keyRules []archie.KeyRule
+	dateRules := 0
+
+	for i, ruleConfig := range ruleConfigs {
+		rewrites := 0
+		for _, rewrite := range []string{ruleConfig.AddPrefix, ruleConfig.DatePrefix, ruleConfig.Pattern, ruleConfig.StripPrefix} {
+			if rewrite != "" {
+				rewrites++
+			}
+		}
+		if rewrites != 1 {
+			log.Fatal().Int("rule", i).Msg("Key rule must set exactly one of: addPrefix, datePrefix, pattern, stripPrefix")
+		}
+		if ruleConfig.Replace != "" && ruleConfig.Pattern == "" {
+			log.Fatal().Int("rule", i).Msg("Key rule replace is only used with a pattern")
+		}
+
+		keyRule := archie.KeyRule{
+			AddPrefix:   ruleConfig.AddPrefix,
+			DatePrefix:  ruleConfig.DatePrefix,
+			Replace:     ruleConfig.Replace,
+			StripPrefix: ruleConfig.StripPrefix,
+		}
+
+		if ruleConfig.DatePrefix != "" {
+			// a layout without date elements formats every time the same
+			if time.Unix(0, 0).UTC().Format(ruleConfig.DatePrefix) == time.Now().UTC().Format(ruleConfig.DatePrefix) {
+				log.Fatal().Int("rule", i).Str("datePrefix", ruleConfig.DatePrefix).Msg("Key rule datePrefix is not a go time layout")
+			}
+			dateRules++
+		}
+
+		if ruleConfig.Pattern != "" {
+			keyRegexp, err := pcre.Compile(ruleConfig.Pattern)
+			if err != nil {
+				log.Fatal().Err(err).Str("pattern", ruleConfig.Pattern).Msg("Failed to compile key rule pcre regex")
+			}
+			keyRule.Pattern = keyRegexp
+		}
+
+		keyRules = append(keyRules, keyRule)
+	}
+
+	// a destination key holds a single date partition
+	if dateRules > 1 {
+		log.Fatal().Msg("Only one key rule can set a datePrefix")
+	}
+
+	return keyRules
+}
+
+// the deletes look up the date partitioned destination keys in the key index, it's only kept with a datePrefix rule
+func setupKeyIndex(cfg Config, natsClient *nats.Conn, a *archie.Archiver) {
+	if !datePartitioned(cfg) {
+		return
+	}
+
+	a.KeyIndexKV = client.KeyValue(
+		natsClient,
+		cfg.Jetstream.KeyIndexBucket,
+		"",
+		cfg.Jetstream.Stream.Replicas,
+		cfg.Jetstream.ProvisioningDisabled,
+	)
+}
+
+func datePartitioned(cfg Config) bool {
+	for _, ruleConfig := range cfg.KeyRules {
+		if ruleConfig.DatePrefix != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setup the source and destination clients, the returned func cancels their health checks
+func setupClients(ctx context.Context, cfg Config, a *archie.Archiver) context.CancelFunc {
+	var healthCheckCancels []context.CancelFunc
+
+	// source
+	c := newClient(cfg.Src.Name, cfg.Src.Type, cfg.Src.GoogleCredentials)
+
+	srcHealthCheckCancel := c.New(
+		ctx,
+		cfg.Src.Name,
+		cfg.Src.Bucket,
+		cfg.Src.Endpoint,
+		client.Credentials{
+			MinioSecretAccessKey:  cfg.Src.SecretKey,
+			MinioAccessKey:        cfg.Src.AccessKey,
+			GoogleCredentials:     cfg.Src.GoogleCredentials,
+			AWSSessionToken:       cfg.Src.SessionToken,
+			AWSRoleARN:            cfg.Src.RoleARN,
+			AWSRoleSessionName:    cfg.Src.RoleSessionName,
+			AWSSTSEndpoint:        cfg.Src.STSEndpoint,
+			AzureAccountKey:       cfg.Src.AzureAccountKey,
+			AzureAccountName:      cfg.Src.AzureAccountName,
+			AzureConnectionString: cfg.Src.AzureConnection,
+		},
+		cfg.Src.UseSSL,
+		client.Params{
+			BucketLookup: cfg.Src.BucketLookup,
+			Region:       cfg.Src.Region,
+		},
+		zerolog.GlobalLevel(),
+	)
+
+	a.SrcClient = c
+	healthCheckCancels = append(healthCheckCancels, srcHealthCheckCancel)
+
+	// destinations, the single dest block is kept for older config files
+	destConfigs := cfg.Destinations
+	if len(destConfigs) == 0 {
+		destConfigs = []DestConfig{cfg.Dest}
+	} else if cfg.Dest.Bucket != "" {
+		log.Fatal().Msg("Use either dest or destinations, not both")
+	}
+
+	destNames := map[string]bool{}
+	for _, destConfig := range destConfigs {
+		if destNames[destConfig.Name] {
+			log.Fatal().Msgf("Destination name %s is not unique", destConfig.Name)
+		}
+		destNames[destConfig.Name] = true
+
+		dest, destHealthCheckCancel := newDestination(ctx, destConfig)
+		// a server-side copy would store the object unencrypted
+		if !destConfig.ServerSideCopyDisabled && a.Encryption == nil && client.CanCopy(a.SrcClient, dest.Client) {
+			log.Info().Msgf("Server-side copy enabled for the %s destination", dest.Name)
+			dest.ServerSideCopy = true
+		}
+		a.Destinations = append(a.Destinations, dest)
+		healthCheckCancels = append(healthCheckCancels, destHealthCheckCancel)
+	}
+
+	a.Routes, a.DefaultRoute = newRoutes(cfg, a.Destinations)
+	a.Manifest = newManifest(cfg, a.Destinations)
+
+	return func() {
+		log.Trace().Msg("Deferred client health check contexts canceled")
+		for _, healthCheckCancel := range healthCheckCancels {
+			healthCheckCancel()
+		}
+	}
+}
+
+// compile the routes and resolve their destination names, the default route
+// goes to every destination unless it lists its own
+func newRoutes(cfg Config, destinations []*archie.Destination) ([]*archie.Route, *archie.Route) {
+	var routes []*archie.Route
+	for i, routeConfig := range cfg.Routes {
+		if routeConfig.Name == "" {
+			routeConfig.Name = fmt.Sprintf("route-%d", i)
+		}
+		if routeConfig.Pattern == "" {
+			log.Fatal().Str("route", routeConfig.Name).Msg("Route pattern is required, use defaultRoute for the unmatched keys")
+		}
+		if len(routeConfig.Destinations) == 0 {
+			log.Fatal().Str("route", routeConfig.Name).Msg("Route needs at least one destination")
+		}
+
+		route := newRoute(routeConfig, destinations)
+
+		routeRegexp, err := pcre.Compile(routeConfig.Pattern)
+		if err != nil {
+			log.Fatal().Err(err).Str("pattern", routeConfig.Pattern).Msg("Failed to compile route pcre regex")
+		}
+		route.Pattern = routeRegexp
+
+		routes = append(routes, route)
+	}
+
+	defaultConfig := cfg.DefaultRoute
+	if defaultConfig.Name == "" {
+		defaultConfig.Name = "default"
+	}
+	if defaultConfig.Pattern != "" {
+		log.Fatal().Msg("The default route can't have a pattern")
+	}
+	defaultRoute := newRoute(defaultConfig, destinations)
+	if len(defaultConfig.Destinations) == 0 {
+		defaultRoute.Destinations = destinations
+	}
+
+	for _, route := range append(routes, defaultRoute) {
+		checkRouteObjectLock(route)
+
+		log.Info().
+			Str("route", route.Name).
+			Strs("destinations", archie.DestinationNames(route.Destinations)).
+			Bool("copyOnly", route.CopyOnly).
+			Bool("deleteOnly", route.DeleteOnly).
+			Str("compression", route.Compression).
+			Str("storageClass", route.StorageClass).
+			Interface("storageClasses", route.StorageClasses).
+			Str("retentionMode", route.RetentionMode).
+			Str("retentionPeriod", route.RetentionPeriod.String()).
+			Bool("legalHold", route.LegalHold).
+			Msg("Route setup")
+	}
+
+	return routes, defaultRoute
+}
+
+func newRoute(routeConfig RouteConfig, destinations []*archie.Destination) *archie.Route {
+	if routeConfig.CopyOnly && routeConfig.DeleteOnly {
+		log.Fatal().Str("route", routeConfig.Name).Msg("Route can't be both copyOnly and deleteOnly")
+	}
+
+	if routeConfig.Compression != "" && !compression.Valid(routeConfig.Compression) {
+		log.Fatal().Str("route", routeConfig.Name).Msgf("Route compression must be %s or %s", compression.Gzip, compression.Zstd)
+	}
+
+	var retentionPeriod time.Duration
+	switch routeConfig.RetentionMode {
+	case "":
+		if routeConfig.RetentionPeriod != "" {
+			log.Fatal().Str("route", routeConfig.Name).Msg("Route retentionPeriod needs a retentionMode")
+		}
+	case "GOVERNANCE", "COMPLIANCE":
+		var err error
+		retentionPeriod, err = time.ParseDuration(routeConfig.RetentionPeriod)
+		if err != nil || retentionPeriod <= 0 {
+			log.Fatal().Err(err).Str("route", routeConfig.Name).Msg("Failed to parse route retention period duration argument")
+		}
+	default:
+		log.Fatal().Str("route", routeConfig.Name).Msg("Route retentionMode must be GOVERNANCE or COMPLIANCE")
+	}
+
+	route := &archie.Route{
+		Compression:       routeConfig.Compression,
+		CompressionSuffix: routeConfig.CompressionSuffix,
+		CopyOnly:          routeConfig.CopyOnly,
+		DeleteOnly:        routeConfig.DeleteOnly,
+		LegalHold:         routeConfig.LegalHold,
+		Name:              routeConfig.Name,
+		RetentionMode:     routeConfig.RetentionMode,
+		RetentionPeriod:   retentionPeriod,
+		StorageClass:      routeConfig.StorageClass,
+		StorageClasses:    routeConfig.StorageClasses,
+	}
+
+	for _, destName := range routeConfig.Destinations {
+		found := false
+		for _, dest := range destinations {
+			if dest.Name == destName {
+				route.Destinations = append(route.Destinations, dest)
+				found = true
+			}
+		}
+		if !found {
+			log.Fatal().Str("route", routeConfig.Name).Msgf("Route destination %s is not configured", destName)
+		}
+	}
+
+	for destName := range routeConfig.StorageClasses {
+		found := false
+		for _, dest := range destinations {
+			found = found || dest.Name == destName
+		}
+		if !found {
+			log.Fatal().Str("route", routeConfig.Name).Msgf("Route storage class destination %s is not configured", destName)
+		}
+	}
+
+	return route
+}
+
+// the route's destinations have to support the object lock it asks for
+func checkRouteObjectLock(route *archie.Route) {
+	for _, dest := range route.Destinations {
+		if route.RetentionMode != "" && !client.CanRetain(dest.Client) {
+			log.Fatal().Str("route", route.Name).Msgf("Route destination %s can't retain objects, use a bucket retention policy", dest.Name)
+		}
+		if route.LegalHold && !client.CanLegalHold(dest.Client) {
+			log.Fatal().Str("route", route.Name).Msgf("Route destination %s can't put objects under a legal hold", dest.Name)
+		}
+	}
+}
+
+func newDestination(ctx context.Context, destConfig DestConfig) (*archie.Destination, context.CancelFunc) {
+	d := newClient(destConfig.Name, destConfig.Type, destConfig.GoogleCredentials)
+
+	healthCheckCancel := d.New(
+		ctx,
+		destConfig.Name,
+		destConfig.Bucket,
+		destConfig.Endpoint,
+		client.Credentials{
+			MinioSecretAccessKey:  destConfig.SecretKey,
+			MinioAccessKey:        destConfig.AccessKey,
+			GoogleCredentials:     destConfig.GoogleCredentials,
+			AWSSessionToken:       destConfig.SessionToken,
+			AWSRoleARN:            destConfig.RoleARN,
+			AWSRoleSessionName:    destConfig.RoleSessionName,
+			AWSSTSEndpoint:        destConfig.STSEndpoint,
+			AzureAccountKey:       destConfig.AzureAccountKey,
+			AzureAccountName:      destConfig.AzureAccountName,
+			AzureConnectionString: destConfig.AzureConnection,
+		},
+		destConfig.UseSSL,
+		client.Params{
+			BucketLookup: destConfig.BucketLookup,
+			PartSize:     destConfig.PartSize,
+			Region:       destConfig.Region,
+			Threads:      destConfig.Threads,
+		},
+		zerolog.GlobalLevel(),
+	)
+
+	dest := &archie.Destination{
+		Bucket:   destConfig.Bucket,
+		Client:   d,
+		Name:     destConfig.Name,
+		PartSize: destConfig.PartSize,
+		Threads:  destConfig.Threads,
+	}
+
+	return dest, healthCheckCancel
+}
+
+// pick the client implementation by its config type, without a type
+// fall back to guessing from the credentials for older config files
+func newClient(name, clientType, googleCredentials string) client.Client {
+	switch clientType {
+	case "minio":
+		return &client.Minio{}
+	case "s3":
+		return &client.S3{}
+	case "gcs":
+		return &client.GCS{}
+	case "azure":
+		return &client.Azure{}
+	case "filesystem":
+		return &client.Filesystem{}
+	case "":
+		if googleCredentials != "" {
+			return &client.GCS{}
+		}
+		return &client.Minio{}
+	}
+
+	log.Fatal().Msgf("Unknown %s client type %s, must be one of: minio, s3, gcs, azure, filesystem", name, clientType)
+	return nil
+}
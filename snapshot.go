@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archie/archie"
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// snapshot lists the source objects as of a time from the manifest of the applied copies and deletes
+func snapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "config file path")
+	logLevelFlag := flags.String("log-level", LookupEnvOrString("LOG_LEVEL", ""), "set the log level (default: info)")
+	atFlag := flags.String("at", "", "list the objects as of this RFC3339 time (default: now)")
+	prefix := flags.String("prefix", "", "only list the keys with this prefix")
+	destination := flags.String("destination", "", "only count the copies and deletes applied to this destination")
+	output := flags.String("output", "-", "file to write the jsonl object list to, - for stdout")
+	_ = flags.Parse(args)
+
+	cfg := loadConfig(*configFile)
+	setLogLevel(*logLevelFlag, cfg.LogLevel)
+
+	at := time.Now().UTC()
+	if *atFlag != "" {
+		var err error
+		at, err = time.Parse(time.RFC3339, *atFlag)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse the -at argument")
+		}
+	}
+
+	// stop cleanly on ctrl-c
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	a := newArchiver(cfg)
+
+	healthCheckCancel := setupClients(ctx, cfg, a)
+	defer healthCheckCancel()
+
+	if a.Manifest == nil {
+		log.Fatal().Msg("The manifest is not enabled, there's nothing to snapshot")
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create the -output file")
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	log.Info().
+		Time("at", at).
+		Str("prefix", *prefix).
+		Str("manifestDestination", a.Manifest.Destination.Name).
+		Msg("Snapshot started")
+
+	objects, err := a.Snapshot(ctx, archie.SnapshotOptions{
+		Destination: *destination,
+		Prefix:      *prefix,
+		Time:        at,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Snapshot stopped")
+		return 1
+	}
+
+	buffered := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(buffered)
+
+	var totalSize int64
+	for _, object := range objects {
+		err = encoder.Encode(object)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to write the snapshot")
+			return 1
+		}
+		totalSize += object.Size
+	}
+
+	err = buffered.Flush()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write the snapshot")
+		return 1
+	}
+
+	log.Info().Int("objects", len(objects)).Int64("size", totalSize).Msg("Snapshot complete")
+	return 0
+}